@@ -0,0 +1,109 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+
+	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+)
+
+// Future is a task created by CreateAsync which is being delivered to the broker.
+type Future struct {
+	done chan struct{}
+	err  error
+	id   string
+}
+
+// ID returns ID of the task, it's known before delivery.
+func (f *Future) ID() string {
+	return f.id
+}
+
+// Done returns channel which is closed when delivery of the task is finished.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait waits for delivery of the task and returns its error.
+func (f *Future) Wait(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", ErrCreateAsync, ctx.Err())
+	}
+}
+
+// CreateAsync creates task for immediate processing without waiting for its delivery to the broker.
+// Result of the delivery is reported by returned future and by callback set with WithCallback. When
+// amount of tasks which are not delivered yet reaches limit set by WithMaxInFlight, CreateAsync waits
+// for delivery of previous tasks or till ctx is done.
+//
+// Message is published synchronously in background goroutine instead of asynchronous send of the
+// provider, because the latter doesn't confirm delivery and the future would report success of the
+// lost task.
+func (t *Tasks) CreateAsync(
+	ctx context.Context,
+	taskName string,
+	params map[string]string,
+	opts ...CreateOption,
+) (*Future, error) {
+	// Stop waits for deliveries which are started before it, so new ones are refused then.
+	t.asyncMutex.Lock()
+	if t.asyncClosed {
+		t.asyncMutex.Unlock()
+		return nil, fmt.Errorf("%w: %w", ErrCreateAsync, ErrStopped)
+	}
+
+	t.wgAsync.Add(1)
+	t.asyncMutex.Unlock()
+
+	select {
+	case t.inFlight <- struct{}{}:
+	case <-ctx.Done():
+		t.wgAsync.Done()
+		return nil, fmt.Errorf("%w: %w", ErrCreateAsync, ctx.Err())
+	}
+
+	co := newCreateOptions(opts)
+	task := newTask(ctx, taskName, params, co)
+	t.checkKey(task)
+
+	msg, err := newMessage(t.topicFor(task.Name), task, task)
+	if err != nil {
+		<-t.inFlight
+		t.wgAsync.Done()
+
+		return nil, fmt.Errorf("%w: %w", ErrCreateAsync, err)
+	}
+
+	t.updateStatus(ctx, task, func(status *models.TaskStatus) {
+		status.State = models.TaskStatePending
+	})
+
+	future := &Future{done: make(chan struct{}), id: task.ID}
+
+	// Delivery outlives the caller, e.g. request handler which creates the task.
+	ctx = context.WithoutCancel(ctx)
+
+	// Message is sent synchronously in background, so delivery is confirmed by the broker.
+	go func() {
+		defer t.wgAsync.Done()
+
+		err := t.broker.Publish(ctx, msg)
+		if err != nil {
+			t.markDead(ctx, task, err)
+			future.err = fmt.Errorf("%w: send task: %w", ErrCreateAsync, err)
+		}
+
+		close(future.done)
+
+		if co.callback != nil {
+			co.callback(task.ID, future.err)
+		}
+
+		<-t.inFlight
+	}()
+
+	return future, nil
+}
@@ -0,0 +1,97 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+
+	"gitlab.local.iti.domain/mc2/golibs/tasks/broker"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+)
+
+// TaskSpec is a task created by CreateBatch.
+type TaskSpec struct {
+	Params  map[string]string
+	Name    string
+	Options []CreateOption
+}
+
+// BatchResult is a result of creation of the task of the batch: ID of the created task or error.
+type BatchResult struct {
+	Err error
+	ID  string
+}
+
+// CreateBatch creates tasks for immediate processing and returns results in order of specs. Tasks are
+// published at once when broker supports it (see broker.BatchPublisher), so failure of one task doesn't
+// stop creation of others.
+func (t *Tasks) CreateBatch(ctx context.Context, specs []TaskSpec) []BatchResult {
+	results := make([]BatchResult, len(specs))
+	tasks := make([]models.Task, 0, len(specs))
+	msgs := make([]*broker.Message, 0, len(specs))
+	// indexes are indexes of results of the messages.
+	indexes := make([]int, 0, len(specs))
+
+	for i, spec := range specs {
+		task := newTask(ctx, spec.Name, spec.Params, newCreateOptions(spec.Options))
+		t.checkKey(task)
+
+		msg, err := newMessage(t.topicFor(task.Name), task, task)
+		if err != nil {
+			results[i].Err = fmt.Errorf("%w: %w", ErrCreateBatch, err)
+			continue
+		}
+
+		t.updateStatus(ctx, task, func(status *models.TaskStatus) {
+			status.State = models.TaskStatePending
+		})
+
+		tasks = append(tasks, task)
+		msgs = append(msgs, msg)
+		indexes = append(indexes, i)
+	}
+
+	for j, err := range t.publishBatch(ctx, msgs) {
+		i := indexes[j]
+
+		if err != nil {
+			t.markDead(ctx, tasks[j], err)
+			results[i].Err = fmt.Errorf("%w: %w", ErrCreateBatch, err)
+
+			continue
+		}
+
+		results[i].ID = tasks[j].ID
+	}
+
+	return results
+}
+
+// publishBatch publishes messages at once if broker supports it or one by one otherwise.
+func (t *Tasks) publishBatch(ctx context.Context, msgs []*broker.Message) []error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	publisher, ok := t.broker.(broker.BatchPublisher)
+	if ok {
+		errs := publisher.PublishBatch(ctx, msgs)
+		for i, err := range errs {
+			if err != nil {
+				errs[i] = fmt.Errorf("send task: %w", err)
+			}
+		}
+
+		return errs
+	}
+
+	errs := make([]error, len(msgs))
+
+	for i, msg := range msgs {
+		err := t.broker.Publish(ctx, msg)
+		if err != nil {
+			errs[i] = fmt.Errorf("send task: %w", err)
+		}
+	}
+
+	return errs
+}
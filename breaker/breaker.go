@@ -0,0 +1,264 @@
+// Package breaker provides circuit breaker which stops calls of failing dependency for a while.
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+// State is a state of the circuit breaker.
+type State string
+
+const (
+	// StateClosed means calls are allowed, failures are counted.
+	StateClosed State = "closed"
+	// StateOpen means calls are rejected until open timeout is over.
+	StateOpen State = "open"
+	// StateHalfOpen means limited amount of trial calls is allowed to check whether dependency recovered.
+	StateHalfOpen State = "half_open"
+)
+
+const (
+	defaultWindow           = time.Minute
+	defaultMinRequests      = 10
+	defaultFailureRate      = 0.5
+	defaultOpenTimeout      = 30 * time.Second
+	defaultHalfOpenRequests = 1
+
+	// windowBuckets is amount of buckets of the rolling window.
+	windowBuckets = 10
+)
+
+// StateChangeFunc is called on every state change of the breaker.
+type StateChangeFunc func(name string, from, to State)
+
+// Config is a configuration of the circuit breaker, empty fields get default values.
+type Config struct {
+	// OnStateChange is called on state change, it's called synchronously, so it shouldn't block.
+	OnStateChange StateChangeFunc
+	// Window is a rolling window in which failure rate is calculated. default value is 1 minute
+	Window time.Duration
+	// OpenTimeout is a time breaker stays open before trial calls. default value is 30 seconds
+	OpenTimeout time.Duration
+	// FailureRate is a share of failed calls in the window (0..1] which opens breaker. default value is 0.5
+	FailureRate float64
+	// MinRequests is a minimum amount of calls in the window to open breaker. default value is 10
+	MinRequests int
+	// HalfOpenRequests is an amount of successful trial calls which closes breaker. default value is 1
+	HalfOpenRequests int
+}
+
+// Stats is a snapshot of the breaker state and counters of the current window.
+type Stats struct {
+	ChangedAt time.Time `json:"changed_at"`
+	Name      string    `json:"name"`
+	State     State     `json:"state"`
+	Requests  int       `json:"requests"`
+	Failures  int       `json:"failures"`
+	// Rejected is a total amount of calls rejected while breaker was open.
+	Rejected int64 `json:"rejected"`
+}
+
+type bucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+// Breaker is a circuit breaker with failure rate calculated in rolling window.
+type Breaker struct {
+	changedAt time.Time
+	name      string
+	state     State
+	buckets   []bucket
+	cfg       Config
+	rejected  int64
+	// trials is an amount of trial calls which are in progress or succeeded in half-open state.
+	trials    int
+	succeeded int
+	// generation is changed on every state change, so results of calls allowed before are ignored.
+	generation uint64
+	// changes are state changes which are reported to OnStateChange after unlock.
+	changes []State
+	mu      sync.Mutex
+}
+
+// New creates closed circuit breaker.
+func New(name string, cfg Config) *Breaker {
+	if cfg.Window <= 0 {
+		cfg.Window = defaultWindow
+	}
+
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = defaultOpenTimeout
+	}
+
+	if cfg.FailureRate <= 0 || cfg.FailureRate > 1 {
+		cfg.FailureRate = defaultFailureRate
+	}
+
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = defaultMinRequests
+	}
+
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = defaultHalfOpenRequests
+	}
+
+	return &Breaker{
+		changedAt: time.Now().UTC(),
+		name:      name,
+		state:     StateClosed,
+		buckets:   make([]bucket, windowBuckets),
+		cfg:       cfg,
+	}
+}
+
+// Allow reports whether call is allowed. If it isn't, it returns time after which breaker
+// allows trial calls. Every allowed call must be followed by Done with returned generation.
+func (b *Breaker) Allow() (bool, time.Duration, uint64) {
+	b.mu.Lock()
+	defer b.unlock()
+
+	now := time.Now().UTC()
+
+	if b.state == StateOpen {
+		wait := b.changedAt.Add(b.cfg.OpenTimeout).Sub(now)
+		if wait > 0 {
+			b.rejected++
+			return false, wait, b.generation
+		}
+
+		b.setState(StateHalfOpen, now)
+	}
+
+	if b.state == StateHalfOpen {
+		if b.trials >= b.cfg.HalfOpenRequests {
+			b.rejected++
+			// Result of the trial calls is not known yet.
+			return false, b.cfg.OpenTimeout, b.generation
+		}
+
+		b.trials++
+	}
+
+	return true, 0, b.generation
+}
+
+// Done records result of the call allowed in generation. Result is ignored if state of the breaker
+// was changed after the call was allowed, e.g. slow call started before breaker opened.
+func (b *Breaker) Done(generation uint64, success bool) {
+	b.mu.Lock()
+	defer b.unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	now := time.Now().UTC()
+
+	switch b.state {
+	case StateHalfOpen:
+		if !success {
+			b.setState(StateOpen, now)
+			return
+		}
+
+		b.succeeded++
+		if b.succeeded >= b.cfg.HalfOpenRequests {
+			b.setState(StateClosed, now)
+		}
+
+	case StateClosed:
+		current := b.bucket(now)
+		current.requests++
+
+		if !success {
+			current.failures++
+		}
+
+		requests, failures := b.counters(now)
+		if requests >= b.cfg.MinRequests && float64(failures) >= b.cfg.FailureRate*float64(requests) {
+			b.setState(StateOpen, now)
+		}
+
+	case StateOpen:
+		// Calls are not allowed in open state, so generation doesn't match.
+	}
+}
+
+// Stats returns current state and counters of the breaker.
+func (b *Breaker) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	requests, failures := b.counters(time.Now().UTC())
+
+	return Stats{
+		ChangedAt: b.changedAt,
+		Name:      b.name,
+		State:     b.state,
+		Requests:  requests,
+		Failures:  failures,
+		Rejected:  b.rejected,
+	}
+}
+
+func (b *Breaker) setState(state State, now time.Time) {
+	if len(b.changes) == 0 {
+		b.changes = append(b.changes, b.state)
+	}
+
+	b.changes = append(b.changes, state)
+	b.state = state
+	b.generation++
+	b.changedAt = now
+	b.trials = 0
+	b.succeeded = 0
+
+	// Window starts from scratch, so failures before opening don't open breaker again.
+	clear(b.buckets)
+}
+
+// unlock unlocks breaker and reports state changes, so hook could use the breaker.
+func (b *Breaker) unlock() {
+	changes := b.changes
+	b.changes = nil
+
+	b.mu.Unlock()
+
+	if b.cfg.OnStateChange == nil {
+		return
+	}
+
+	for i := 1; i < len(changes); i++ {
+		b.cfg.OnStateChange(b.name, changes[i-1], changes[i])
+	}
+}
+
+// bucket returns bucket of the window for now, resetting it if it's outdated.
+func (b *Breaker) bucket(now time.Time) *bucket {
+	size := max(b.cfg.Window/windowBuckets, 1)
+	start := now.Truncate(size)
+	current := &b.buckets[int(start.UnixNano()/int64(size))%windowBuckets]
+
+	if !current.start.Equal(start) {
+		*current = bucket{start: start}
+	}
+
+	return current
+}
+
+// counters returns amount of calls and failures in the window.
+func (b *Breaker) counters(now time.Time) (int, int) {
+	var requests, failures int
+
+	for _, bucket := range b.buckets {
+		if now.Sub(bucket.start) < b.cfg.Window {
+			requests += bucket.requests
+			failures += bucket.failures
+		}
+	}
+
+	return requests, failures
+}
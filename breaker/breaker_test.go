@@ -0,0 +1,108 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker(t *testing.T) {
+	t.Parallel()
+
+	var changes []State
+
+	b := New("test", Config{
+		MinRequests:      4,
+		FailureRate:      0.5,
+		OpenTimeout:      100 * time.Millisecond,
+		HalfOpenRequests: 2,
+		OnStateChange: func(name string, _, to State) {
+			require.Equal(t, "test", name)
+
+			changes = append(changes, to)
+		},
+	})
+
+	done := func(success bool) {
+		allowed, _, generation := b.Allow()
+		require.True(t, allowed)
+		b.Done(generation, success)
+	}
+
+	// Failure rate is below threshold.
+	done(true)
+	done(false)
+	done(true)
+	require.Equal(t, StateClosed, b.Stats().State)
+
+	// 2 of 4 calls failed.
+	done(false)
+	require.Equal(t, StateOpen, b.Stats().State)
+
+	allowed, wait, _ := b.Allow()
+	require.False(t, allowed)
+	require.Greater(t, wait, time.Duration(0))
+	require.LessOrEqual(t, wait, 100*time.Millisecond)
+	require.Equal(t, int64(1), b.Stats().Rejected)
+
+	time.Sleep(wait)
+
+	// Trial call failed.
+	done(false)
+	require.Equal(t, StateOpen, b.Stats().State)
+
+	time.Sleep(100 * time.Millisecond)
+
+	// Only HalfOpenRequests trial calls are allowed at once.
+	allowed, _, generation := b.Allow()
+	require.True(t, allowed)
+	allowed, _, _ = b.Allow()
+	require.True(t, allowed)
+	allowed, _, _ = b.Allow()
+	require.False(t, allowed)
+	require.Equal(t, StateHalfOpen, b.Stats().State)
+
+	b.Done(generation, true)
+	b.Done(generation, true)
+
+	stats := b.Stats()
+	require.Equal(t, StateClosed, stats.State)
+	require.Zero(t, stats.Requests)
+	require.Equal(t, []State{StateOpen, StateHalfOpen, StateOpen, StateHalfOpen, StateClosed}, changes)
+}
+
+func TestBreakerStaleResult(t *testing.T) {
+	t.Parallel()
+
+	b := New("test", Config{MinRequests: 2, OpenTimeout: 50 * time.Millisecond})
+
+	// Slow call is allowed before breaker opens.
+	_, _, slow := b.Allow()
+
+	for range 2 {
+		_, _, generation := b.Allow()
+		b.Done(generation, false)
+	}
+
+	require.Equal(t, StateOpen, b.Stats().State)
+
+	time.Sleep(50 * time.Millisecond)
+
+	allowed, _, trial := b.Allow()
+	require.True(t, allowed)
+	require.Equal(t, StateHalfOpen, b.Stats().State)
+
+	// Result of the slow call doesn't close or reopen breaker.
+	b.Done(slow, true)
+	require.Equal(t, StateHalfOpen, b.Stats().State)
+	b.Done(slow, false)
+	require.Equal(t, StateHalfOpen, b.Stats().State)
+
+	b.Done(trial, true)
+	require.Equal(t, StateClosed, b.Stats().State)
+
+	// Failure of the slow call isn't counted in the new window.
+	b.Done(slow, false)
+	require.Zero(t, b.Stats().Requests)
+}
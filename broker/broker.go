@@ -0,0 +1,74 @@
+// Package broker provides message broker abstraction: messages are published to topics and delivered
+// to subscribers which acknowledge them.
+package broker
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"time"
+)
+
+var (
+	// ErrClosed appears on usage of the closed broker.
+	ErrClosed = errors.New("broker is closed")
+	// ErrUnknownMessage appears on Ack or Nack of message which isn't waiting for acknowledge.
+	ErrUnknownMessage = errors.New("unknown message")
+)
+
+// Message is a message of the broker.
+type Message struct {
+	Headers map[string]string
+	Topic   string
+	// Key selects partition of the message, messages with the same key are delivered in order.
+	Key  string
+	Body []byte
+	// Partition and Offset are set by broker on delivery, they identify message on Ack and Nack.
+	Partition int
+	Offset    int64
+}
+
+// Handler handles delivered message. Every delivered message must be acknowledged by Ack or Nack
+// of the broker, it could be done after handler returns.
+type Handler func(ctx context.Context, msg *Message)
+
+// Broker is an interface of message broker which could be provided on initialization.
+type Broker interface {
+	// Publish sends message to the topic.
+	Publish(ctx context.Context, msg *Message) error
+	// Subscribe starts delivery of the topic messages to the handler.
+	Subscribe(topic string, handler Handler) error
+	// Ack confirms message is handled, so it isn't delivered again.
+	Ack(ctx context.Context, msg *Message) error
+	// Nack returns message to the broker, so it's delivered again.
+	Nack(ctx context.Context, msg *Message) error
+	// Close stops delivery of messages.
+	Close() error
+}
+
+// KeyOrderer is implemented by brokers which deliver messages sharing a key to one subscriber in order
+// of publishing, e.g. messages of one partition.
+type KeyOrderer interface {
+	// KeyOrdered reports whether messages sharing a key are delivered in order.
+	KeyOrdered() bool
+}
+
+// AckTimeouter is implemented by brokers which deliver message again when it isn't acked in time.
+type AckTimeouter interface {
+	// AckTimeout returns time in which handled message must be acked, zero if it isn't limited.
+	AckTimeout() time.Duration
+}
+
+// BatchPublisher is implemented by brokers which send several messages at once faster than one by one.
+type BatchPublisher interface {
+	// PublishBatch sends messages and returns error of every message, error is nil if message is sent.
+	PublishBatch(ctx context.Context, msgs []*Message) []error
+}
+
+// clone returns copy of the message, so handler could change it.
+func (m *Message) clone() *Message {
+	msg := *m
+	msg.Headers = maps.Clone(m.Headers)
+
+	return &msg
+}
@@ -0,0 +1,153 @@
+package broker
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/mocks"
+)
+
+func TestMemory(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b := NewMemory(WithPartitions(3), WithRedeliveryDelay(10*time.Millisecond), WithAckTimeout(50*time.Millisecond))
+
+	defer b.Close()
+
+	// Messages published before subscription are kept.
+	for i := range 10 {
+		err := b.Publish(ctx, &Message{Topic: "test", Key: "ordered", Body: []byte(strconv.Itoa(i))})
+		require.NoError(t, err)
+	}
+
+	err := b.Publish(ctx, &Message{Topic: "test", Key: "nack", Body: []byte("nack")})
+	require.NoError(t, err)
+
+	err = b.Publish(ctx, &Message{Topic: "test", Key: "lost", Body: []byte("lost")})
+	require.NoError(t, err)
+
+	var (
+		ordered    []string
+		deliveries = make(map[string]int)
+		mu         sync.Mutex
+	)
+
+	done := make(chan struct{})
+
+	err = b.Subscribe("test", func(ctx context.Context, msg *Message) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		body := string(msg.Body)
+		deliveries[body]++
+
+		switch {
+		case body == "nack" && deliveries[body] == 1:
+			require.NoError(t, b.Nack(ctx, msg))
+		case body == "lost" && deliveries[body] == 1:
+			// Neither acked nor nacked message is delivered again after ack timeout.
+		default:
+			require.NoError(t, b.Ack(ctx, msg))
+
+			if msg.Key == "ordered" {
+				ordered = append(ordered, body)
+			}
+		}
+
+		if len(ordered) == 10 && deliveries["nack"] == 2 && deliveries["lost"] == 2 {
+			close(done)
+		}
+	})
+	require.NoError(t, err)
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("messages were not delivered")
+	}
+
+	require.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, ordered)
+	require.ErrorIs(t, b.Ack(ctx, &Message{Topic: "test", Offset: 100}), ErrUnknownMessage)
+
+	require.NoError(t, b.Close())
+	require.ErrorIs(t, b.Publish(ctx, &Message{Topic: "test"}), ErrClosed)
+}
+
+func TestMemoryWithoutAckTimeout(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b := NewMemory(WithAckTimeout(0))
+
+	defer b.Close()
+
+	require.Zero(t, b.AckTimeout())
+
+	delivered := make(chan *Message, 2)
+
+	err := b.Subscribe("test", func(_ context.Context, msg *Message) {
+		delivered <- msg
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, b.Publish(ctx, &Message{Topic: "test", Body: []byte("running")}))
+
+	// Message which isn't acked yet is not delivered again.
+	msg := <-delivered
+
+	select {
+	case <-delivered:
+		t.Fatal("message was delivered again")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	require.NoError(t, b.Ack(ctx, msg))
+}
+
+func TestProvider(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b := NewProvider(mocks.New())
+
+	nack := true
+
+	err := b.Subscribe("test", func(ctx context.Context, msg *Message) {
+		require.Equal(t, "test", msg.Topic)
+		require.Equal(t, "body", string(msg.Body))
+		require.Equal(t, map[string]string{"x-task-name": "name"}, msg.Headers)
+
+		if nack {
+			require.NoError(t, b.Nack(ctx, msg))
+			return
+		}
+
+		require.NoError(t, b.Ack(ctx, msg))
+	})
+	require.NoError(t, err)
+
+	// Mock provider returns handler error, nacked message is kept in the topic.
+	require.Error(t, b.Publish(ctx, &Message{Topic: "test", Body: []byte("body"), Headers: map[string]string{"x-task-name": "name"}}))
+
+	nack = false
+
+	require.NoError(t, b.Publish(ctx, &Message{Topic: "test", Body: []byte("body"), Headers: map[string]string{"x-task-name": "name"}}))
+
+	require.Equal(t, []error{nil, nil}, b.PublishBatch(ctx, []*Message{
+		{Topic: "test", Body: []byte("body"), Headers: map[string]string{"x-task-name": "name"}},
+		{Topic: "test", Body: []byte("body"), Headers: map[string]string{"x-task-name": "name"}},
+	}))
+
+	// Failed delivery is reported for the message of the batch.
+	errs := b.PublishBatch(ctx, []*Message{
+		{Topic: "test", Body: []byte("body"), Headers: map[string]string{"x-task-name": "name"}},
+		{Topic: "unknown", Body: []byte("body")},
+	})
+	require.NoError(t, errs[0])
+	require.Error(t, errs[1])
+}
@@ -0,0 +1,433 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// kafkaRetryDelay is a delay before nacked message is delivered again and before consumption is
+// restarted after consumer group error.
+const kafkaRetryDelay = time.Second
+
+// Kafka is a broker on top of Kafka consumer group. Offset of the message is committed only after
+// the message and all previous messages of the partition are acked, so messages which were not
+// acked before crash or rebalance are delivered again. Nacked message is delivered again after
+// a delay, other messages of the partition and other partitions are not affected.
+type Kafka struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	client   sarama.Client
+	producer sarama.SyncProducer
+	group    sarama.ConsumerGroup
+	handlers map[string]Handler
+	claims   map[kafkaPartition]*kafkaClaim
+	// restart ends current consumer group session, so subscription is updated.
+	restart  context.CancelFunc
+	closeErr error
+	wg       sync.WaitGroup
+	once     sync.Once
+	mu       sync.Mutex
+}
+
+type kafkaPartition struct {
+	topic     string
+	partition int32
+}
+
+// kafkaClaim keeps offsets of the partition delivered in current session.
+type kafkaClaim struct {
+	session sarama.ConsumerGroupSession
+	// offsets are delivered offsets which are not marked yet, in order of delivery.
+	offsets []int64
+	acked   map[int64]bool
+	// nacked are offsets of messages waiting for delivery again, they are passed to redelivered.
+	nacked      map[int64]bool
+	redelivered chan *Message
+}
+
+// NewKafka creates broker which consumes topics in consumer group groupID. New group starts from
+// the oldest offset if config is not set.
+func NewKafka(brokers []string, config *sarama.Config, groupID string) (*Kafka, error) {
+	if config == nil {
+		config = sarama.NewConfig()
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	}
+
+	cfg := *config
+	cfg.Producer.Return.Successes = true
+
+	client, err := sarama.NewClient(brokers, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("kafka broker: %w", err)
+	}
+
+	k, err := newKafkaFromClient(client, groupID)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func newKafkaFromClient(client sarama.Client, groupID string) (*Kafka, error) {
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("kafka broker: producer: %w", err)
+	}
+
+	group, err := sarama.NewConsumerGroupFromClient(groupID, client)
+	if err != nil {
+		_ = producer.Close()
+		return nil, fmt.Errorf("kafka broker: consumer group: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Kafka{
+		ctx:      ctx,
+		cancel:   cancel,
+		client:   client,
+		producer: producer,
+		group:    group,
+		handlers: make(map[string]Handler),
+		claims:   make(map[kafkaPartition]*kafkaClaim),
+	}, nil
+}
+
+// KeyOrdered reports that messages sharing a key are delivered in order, they are kept in one partition.
+func (k *Kafka) KeyOrdered() bool {
+	return true
+}
+
+// Publish sends message to the topic, key of the message selects partition.
+func (k *Kafka) Publish(_ context.Context, msg *Message) error {
+	if k.ctx.Err() != nil {
+		return ErrClosed
+	}
+
+	_, _, err := k.producer.SendMessage(producerMessage(msg))
+	if err != nil {
+		return fmt.Errorf("kafka broker: send message: %w", err)
+	}
+
+	return nil
+}
+
+// PublishBatch sends messages by one request of the producer.
+func (k *Kafka) PublishBatch(_ context.Context, msgs []*Message) []error {
+	errs := make([]error, len(msgs))
+
+	if k.ctx.Err() != nil {
+		for i := range errs {
+			errs[i] = ErrClosed
+		}
+
+		return errs
+	}
+
+	messages := make([]*sarama.ProducerMessage, len(msgs))
+	indexes := make(map[*sarama.ProducerMessage]int, len(msgs))
+
+	for i, msg := range msgs {
+		messages[i] = producerMessage(msg)
+		indexes[messages[i]] = i
+	}
+
+	err := k.producer.SendMessages(messages)
+	if err == nil {
+		return errs
+	}
+
+	var producerErrs sarama.ProducerErrors
+	if !errors.As(err, &producerErrs) {
+		for i := range errs {
+			errs[i] = fmt.Errorf("kafka broker: send message: %w", err)
+		}
+
+		return errs
+	}
+
+	for _, producerErr := range producerErrs {
+		i, ok := indexes[producerErr.Msg]
+		if ok {
+			errs[i] = fmt.Errorf("kafka broker: send message: %w", producerErr.Err)
+		}
+	}
+
+	return errs
+}
+
+// producerMessage converts message to the message of the producer.
+func producerMessage(msg *Message) *sarama.ProducerMessage {
+	message := &sarama.ProducerMessage{Topic: msg.Topic, Value: sarama.ByteEncoder(msg.Body)}
+	if msg.Key != "" {
+		message.Key = sarama.StringEncoder(msg.Key)
+	}
+
+	for key, value := range msg.Headers {
+		message.Headers = append(message.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+	}
+
+	return message
+}
+
+// Subscribe adds topic to the consumer group subscription. Session of the group is restarted,
+// so messages which are not acked yet are delivered again.
+func (k *Kafka) Subscribe(topic string, handler Handler) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.ctx.Err() != nil {
+		return ErrClosed
+	}
+
+	_, subscribed := k.handlers[topic]
+	k.handlers[topic] = handler
+
+	switch {
+	case subscribed:
+	case k.restart != nil:
+		k.restart()
+	default:
+		// The first subscription starts consumption.
+		k.restart = func() {}
+
+		k.wg.Add(1)
+
+		go k.consume()
+	}
+
+	return nil
+}
+
+// Ack marks offset of the message for commit when all previous messages of the partition are acked.
+func (k *Kafka) Ack(_ context.Context, msg *Message) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	claim, err := k.claim(msg)
+	if err != nil {
+		return err
+	}
+
+	claim.acked[msg.Offset] = true
+
+	marked := int64(-1)
+
+	for len(claim.offsets) > 0 && claim.acked[claim.offsets[0]] {
+		marked = claim.offsets[0]
+		delete(claim.acked, marked)
+		claim.offsets = claim.offsets[1:]
+	}
+
+	if marked >= 0 {
+		claim.session.MarkOffset(msg.Topic, int32(msg.Partition), marked+1, "")
+	}
+
+	return nil
+}
+
+// Nack delivers message again after a delay. Offset of the message isn't committed till it's acked,
+// so message is delivered again by the next session if current one ends meanwhile.
+func (k *Kafka) Nack(_ context.Context, msg *Message) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	claim, err := k.claim(msg)
+	if err != nil {
+		return err
+	}
+
+	claim.nacked[msg.Offset] = true
+
+	k.wg.Add(1)
+
+	go k.redeliver(claim, msg)
+
+	return nil
+}
+
+// redeliver passes nacked message to the partition consumer after a delay.
+func (k *Kafka) redeliver(claim *kafkaClaim, msg *Message) {
+	defer k.wg.Done()
+
+	ctx := claim.session.Context()
+
+	if sleep(ctx, kafkaRetryDelay) != nil {
+		return
+	}
+
+	select {
+	case claim.redelivered <- msg:
+	case <-ctx.Done():
+	}
+}
+
+// Close stops consumption and closes connections, repeated calls return result of the first one.
+func (k *Kafka) Close() error {
+	k.once.Do(func() {
+		k.closeErr = k.close()
+	})
+
+	return k.closeErr
+}
+
+func (k *Kafka) close() error {
+	k.cancel()
+	k.wg.Wait()
+
+	err := k.group.Close()
+	if err != nil {
+		return fmt.Errorf("kafka broker: close consumer group: %w", err)
+	}
+
+	err = k.producer.Close()
+	if err != nil {
+		return fmt.Errorf("kafka broker: close producer: %w", err)
+	}
+
+	if !k.client.Closed() {
+		err = k.client.Close()
+		if err != nil {
+			return fmt.Errorf("kafka broker: close client: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// consume runs consumer group sessions until broker is closed.
+func (k *Kafka) consume() {
+	defer k.wg.Done()
+
+	for {
+		k.mu.Lock()
+		topics := slices.Sorted(maps.Keys(k.handlers))
+		ctx, cancel := context.WithCancel(k.ctx)
+		k.restart = cancel
+		k.mu.Unlock()
+
+		err := k.group.Consume(ctx, topics, k)
+
+		cancel()
+
+		if k.ctx.Err() != nil {
+			return
+		}
+
+		// Consumer group errors are logged by sarama, consumption is retried after a delay.
+		if err != nil && ctx.Err() == nil {
+			_ = sleep(k.ctx, kafkaRetryDelay)
+		}
+	}
+}
+
+// Setup is called at the beginning of the consumer group session.
+func (k *Kafka) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// Cleanup forgets offsets of the ended session.
+func (k *Kafka) Cleanup(session sarama.ConsumerGroupSession) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for key, claim := range k.claims {
+		if claim.session == session {
+			delete(k.claims, key)
+		}
+	}
+
+	return nil
+}
+
+// ConsumeClaim passes messages of the partition to the topic handler one by one.
+func (k *Kafka) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	key := kafkaPartition{topic: claim.Topic(), partition: claim.Partition()}
+
+	state := &kafkaClaim{
+		session:     session,
+		acked:       make(map[int64]bool),
+		nacked:      make(map[int64]bool),
+		redelivered: make(chan *Message),
+	}
+
+	k.mu.Lock()
+	handler := k.handlers[claim.Topic()]
+	k.claims[key] = state
+	k.mu.Unlock()
+
+	for {
+		select {
+		case <-session.Context().Done():
+			return nil
+		case msg := <-state.redelivered:
+			k.mu.Lock()
+			delete(state.nacked, msg.Offset)
+			k.mu.Unlock()
+
+			handler(session.Context(), msg)
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+
+			k.mu.Lock()
+			state.offsets = append(state.offsets, message.Offset)
+			k.mu.Unlock()
+
+			handler(session.Context(), newKafkaMessage(message))
+		}
+	}
+}
+
+// claim returns claim of the message which waits for acknowledge in current session.
+func (k *Kafka) claim(msg *Message) (*kafkaClaim, error) {
+	claim, ok := k.claims[kafkaPartition{topic: msg.Topic, partition: int32(msg.Partition)}]
+	if !ok || !slices.Contains(claim.offsets, msg.Offset) || claim.acked[msg.Offset] || claim.nacked[msg.Offset] {
+		return nil, ErrUnknownMessage
+	}
+
+	return claim, nil
+}
+
+func newKafkaMessage(message *sarama.ConsumerMessage) *Message {
+	msg := &Message{
+		Topic:     message.Topic,
+		Key:       string(message.Key),
+		Body:      message.Value,
+		Partition: int(message.Partition),
+		Offset:    message.Offset,
+	}
+
+	if len(message.Headers) > 0 {
+		msg.Headers = make(map[string]string, len(message.Headers))
+
+		for _, header := range message.Headers {
+			msg.Headers[string(header.Key)] = string(header.Value)
+		}
+	}
+
+	return msg
+}
+
+// sleep waits for delay or until context is done.
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
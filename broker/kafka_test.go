@@ -0,0 +1,207 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKafka(t *testing.T) {
+	t.Parallel()
+
+	mockBroker := newMockKafka(t, 1)
+	defer mockBroker.Close()
+
+	b, delivered := newTestKafka(t, mockBroker)
+	defer b.Close()
+
+	ctx := context.Background()
+
+	require.NoError(t, b.Publish(ctx, &Message{Topic: "test", Key: "key", Body: []byte("message")}))
+	require.Equal(t, []error{nil, nil}, b.PublishBatch(ctx, []*Message{
+		{Topic: "test", Key: "key", Body: []byte("message")},
+		{Topic: "test", Body: []byte("message")},
+	}))
+
+	next := func() *Message {
+		return nextMessage(t, delivered)
+	}
+
+	messages := make([]*Message, 0, 4)
+	for range 4 {
+		messages = append(messages, next())
+	}
+
+	require.Equal(t, int64(1), messages[0].Offset)
+
+	// Offset isn't committed until all previous messages are acked.
+	require.NoError(t, b.Ack(ctx, messages[1]))
+	require.NoError(t, b.Ack(ctx, messages[2]))
+	require.ErrorIs(t, b.Ack(ctx, messages[2]), ErrUnknownMessage)
+
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, int64(-1), committedOffset(mockBroker))
+
+	require.NoError(t, b.Ack(ctx, messages[0]))
+	require.Eventually(t, func() bool {
+		return committedOffset(mockBroker) == 4
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Nacked message is delivered again and its offset is committed only after ack.
+	require.NoError(t, b.Nack(ctx, messages[3]))
+	require.ErrorIs(t, b.Ack(ctx, messages[3]), ErrUnknownMessage)
+
+	redelivered := next()
+	require.Equal(t, int64(4), redelivered.Offset)
+	require.Equal(t, int64(4), committedOffset(mockBroker))
+
+	require.NoError(t, b.Ack(ctx, redelivered))
+	require.Eventually(t, func() bool {
+		return committedOffset(mockBroker) == 5
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestKafkaNackPartition(t *testing.T) {
+	t.Parallel()
+
+	mockBroker := newMockKafka(t, 2)
+	defer mockBroker.Close()
+
+	b, delivered := newTestKafka(t, mockBroker)
+	defer b.Close()
+
+	var nacked *Message
+
+	for range 8 {
+		msg := nextMessage(t, delivered)
+		if msg.Partition == 0 && msg.Offset == 2 {
+			nacked = msg
+		}
+	}
+
+	require.NotNil(t, nacked)
+	require.NoError(t, b.Nack(context.Background(), nacked))
+
+	// Only nacked message is delivered again, other partition isn't rewound.
+	redelivered := nextMessage(t, delivered)
+	require.Equal(t, 0, redelivered.Partition)
+	require.Equal(t, int64(2), redelivered.Offset)
+
+	select {
+	case msg := <-delivered:
+		t.Fatalf("message %d of partition %d was delivered again", msg.Offset, msg.Partition)
+	case <-time.After(2 * kafkaRetryDelay):
+	}
+}
+
+// newMockKafka returns broker with partitions of the test topic, every partition has messages with
+// offsets 1-4 and offset 1 is committed already.
+func newMockKafka(t *testing.T, partitions int32) *sarama.MockBroker {
+	t.Helper()
+
+	mockBroker := sarama.NewMockBroker(t, 1)
+
+	metadata := sarama.NewMockMetadataResponse(t).
+		SetBroker(mockBroker.Addr(), mockBroker.BrokerID()).
+		SetController(mockBroker.BrokerID())
+	fetch := sarama.NewMockFetchResponse(t, 10)
+	offsetFetch := sarama.NewMockOffsetFetchResponse(t)
+	offset := sarama.NewMockOffsetResponse(t)
+	assigned := make([]int32, 0, partitions)
+
+	for partition := range partitions {
+		metadata.SetLeader("test", partition, mockBroker.BrokerID())
+		fetch.SetHighWaterMark("test", partition, 5)
+
+		for messageOffset := int64(1); messageOffset < 5; messageOffset++ {
+			fetch.SetMessage("test", partition, messageOffset, sarama.StringEncoder("message"))
+		}
+
+		offsetFetch.SetOffset("group", "test", partition, 1, "", sarama.ErrNoError)
+		offset.SetOffset("test", partition, sarama.OffsetOldest, 0).
+			SetOffset("test", partition, sarama.OffsetNewest, 5)
+
+		assigned = append(assigned, partition)
+	}
+
+	mockBroker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t),
+		"MetadataRequest":    metadata,
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "group", mockBroker),
+		"JoinGroupRequest": sarama.NewMockJoinGroupResponse(t).
+			SetGroupProtocol(sarama.RangeBalanceStrategyName).
+			SetGenerationId(1).
+			SetMemberId("member").
+			SetLeaderId("member").
+			SetMember("member", &sarama.ConsumerGroupMemberMetadata{Topics: []string{"test"}}),
+		"SyncGroupRequest": sarama.NewMockSyncGroupResponse(t).
+			SetMemberAssignment(&sarama.ConsumerGroupMemberAssignment{Topics: map[string][]int32{"test": assigned}}),
+		"HeartbeatRequest":    sarama.NewMockHeartbeatResponse(t),
+		"LeaveGroupRequest":   sarama.NewMockLeaveGroupResponse(t),
+		"OffsetFetchRequest":  offsetFetch,
+		"OffsetRequest":       offset,
+		"FetchRequest":        fetch,
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+		"ProduceRequest":      sarama.NewMockProduceResponse(t),
+	})
+
+	return mockBroker
+}
+
+// newTestKafka returns broker subscribed to the test topic and channel of delivered messages.
+func newTestKafka(t *testing.T, mockBroker *sarama.MockBroker) (*Kafka, chan *Message) {
+	t.Helper()
+
+	config := sarama.NewConfig()
+	config.Consumer.Offsets.AutoCommit.Interval = 10 * time.Millisecond
+
+	b, err := NewKafka([]string{mockBroker.Addr()}, config, "group")
+	require.NoError(t, err)
+
+	delivered := make(chan *Message, 10)
+
+	err = b.Subscribe("test", func(_ context.Context, msg *Message) {
+		delivered <- msg
+	})
+	require.NoError(t, err)
+
+	return b, delivered
+}
+
+// nextMessage returns next delivered message.
+func nextMessage(t *testing.T, delivered chan *Message) *Message {
+	t.Helper()
+
+	select {
+	case msg := <-delivered:
+		require.Equal(t, "message", string(msg.Body))
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was not delivered")
+	}
+
+	return nil
+}
+
+// committedOffset returns the last committed offset of the test partition.
+func committedOffset(mockBroker *sarama.MockBroker) int64 {
+	committed := int64(-1)
+
+	for _, rr := range mockBroker.History() {
+		request, ok := rr.Request.(*sarama.OffsetCommitRequest)
+		if !ok {
+			continue
+		}
+
+		offset, _, err := request.Offset("test", 0)
+		if err == nil {
+			committed = offset
+		}
+	}
+
+	return committed
+}
@@ -0,0 +1,366 @@
+package broker
+
+import (
+	"cmp"
+	"context"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPartitions      = 4
+	defaultRedeliveryDelay = time.Second
+	defaultAckTimeout      = 30 * time.Second
+)
+
+type memoryOptions struct {
+	partitions      int
+	redeliveryDelay time.Duration
+	ackTimeout      time.Duration
+}
+
+// MemoryOption is an interface for configuration options of the in-memory broker.
+type MemoryOption interface {
+	apply(o *memoryOptions)
+}
+
+type partitionsOption struct {
+	partitions int
+}
+
+func (po partitionsOption) apply(o *memoryOptions) {
+	o.partitions = po.partitions
+}
+
+// WithPartitions sets amount of partitions of every topic. default value is 4
+func WithPartitions(partitions int) MemoryOption {
+	return partitionsOption{partitions: partitions}
+}
+
+type redeliveryDelayOption struct {
+	delay time.Duration
+}
+
+func (ro redeliveryDelayOption) apply(o *memoryOptions) {
+	o.redeliveryDelay = ro.delay
+}
+
+// WithRedeliveryDelay sets delay before delivery of nacked message. default value is 1 second
+func WithRedeliveryDelay(delay time.Duration) MemoryOption {
+	return redeliveryDelayOption{delay: delay}
+}
+
+type ackTimeoutOption struct {
+	timeout time.Duration
+}
+
+func (ao ackTimeoutOption) apply(o *memoryOptions) {
+	o.ackTimeout = ao.timeout
+}
+
+// WithAckTimeout sets time after handler return in which message must be acked or nacked, otherwise
+// it's delivered again, zero timeout disables redelivery of unacked messages. default value is 30 seconds.
+// With tasks.WithAckAfterProcessing message is acked after the task waits for a worker and is processed,
+// so timeout must exceed execution timeout and wait in the queue, otherwise running tasks are delivered
+// again.
+func WithAckTimeout(timeout time.Duration) MemoryOption {
+	return ackTimeoutOption{timeout: timeout}
+}
+
+// Memory keeps messages in process memory. It's useful for local development and tests.
+// Messages published before subscription are kept until subscriber appears.
+type Memory struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	topics map[string]*memoryTopic
+	opts   memoryOptions
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+}
+
+type memoryTopic struct {
+	handler    Handler
+	partitions []*memoryPartition
+	// next is a partition of the next message without key.
+	next int
+}
+
+type memoryPartition struct {
+	// pending are messages waiting for delivery ordered by offset.
+	pending  []*Message
+	inFlight map[int64]*delivery
+	notify   chan struct{}
+	offset   int64
+}
+
+// NewMemory creates new in-memory broker.
+func NewMemory(opts ...MemoryOption) *Memory {
+	o := memoryOptions{
+		partitions:      defaultPartitions,
+		redeliveryDelay: defaultRedeliveryDelay,
+		ackTimeout:      defaultAckTimeout,
+	}
+
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+
+	o.partitions = max(o.partitions, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Memory{
+		ctx:    ctx,
+		cancel: cancel,
+		topics: make(map[string]*memoryTopic),
+		opts:   o,
+	}
+}
+
+// KeyOrdered reports that messages sharing a key are delivered in order, they are kept in one partition.
+func (m *Memory) KeyOrdered() bool {
+	return true
+}
+
+// AckTimeout returns time after handler return in which message must be acked.
+func (m *Memory) AckTimeout() time.Duration {
+	return m.opts.ackTimeout
+}
+
+// Publish appends message to the partition selected by key.
+func (m *Memory) Publish(_ context.Context, msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.ctx.Err() != nil {
+		return ErrClosed
+	}
+
+	topic := m.topic(msg.Topic)
+
+	partition := topic.next
+	if msg.Key != "" {
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(msg.Key))
+		partition = int(hash.Sum32() % uint32(len(topic.partitions)))
+	} else {
+		topic.next = (topic.next + 1) % len(topic.partitions)
+	}
+
+	p := topic.partitions[partition]
+
+	stored := msg.clone()
+	stored.Partition = partition
+	stored.Offset = p.offset
+	p.offset++
+
+	p.pending = append(p.pending, stored)
+	p.wakeUp()
+
+	return nil
+}
+
+// Subscribe starts delivery of the topic messages, every partition is delivered in own goroutine.
+// Subscription replaces handler of the previous one.
+func (m *Memory) Subscribe(topic string, handler Handler) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.ctx.Err() != nil {
+		return ErrClosed
+	}
+
+	t := m.topic(topic)
+
+	subscribed := t.handler != nil
+	t.handler = handler
+
+	if subscribed {
+		return nil
+	}
+
+	for _, p := range t.partitions {
+		m.wg.Add(1)
+
+		go m.deliver(t, p)
+	}
+
+	return nil
+}
+
+// Ack removes message from the broker.
+func (m *Memory) Ack(_ context.Context, msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, err := m.inFlight(msg)
+	if err != nil {
+		return err
+	}
+
+	d := p.inFlight[msg.Offset]
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+
+	delete(p.inFlight, msg.Offset)
+
+	return nil
+}
+
+// Nack delivers message again after redelivery delay.
+func (m *Memory) Nack(_ context.Context, msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, err := m.inFlight(msg)
+	if err != nil {
+		return err
+	}
+
+	m.schedule(p, p.inFlight[msg.Offset], m.opts.redeliveryDelay)
+
+	return nil
+}
+
+// Close stops delivery and waits for handlers which are running.
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	m.cancel()
+	m.mu.Unlock()
+
+	m.wg.Wait()
+
+	return nil
+}
+
+// topic returns topic by name, creating it on the first usage.
+func (m *Memory) topic(name string) *memoryTopic {
+	t, ok := m.topics[name]
+	if ok {
+		return t
+	}
+
+	t = &memoryTopic{partitions: make([]*memoryPartition, m.opts.partitions)}
+	for i := range t.partitions {
+		t.partitions[i] = &memoryPartition{
+			inFlight: make(map[int64]*delivery),
+			notify:   make(chan struct{}, 1),
+		}
+	}
+
+	m.topics[name] = t
+
+	return t
+}
+
+// inFlight returns partition of the message which waits for acknowledge.
+func (m *Memory) inFlight(msg *Message) (*memoryPartition, error) {
+	t, ok := m.topics[msg.Topic]
+	if !ok || msg.Partition < 0 || msg.Partition >= len(t.partitions) {
+		return nil, ErrUnknownMessage
+	}
+
+	p := t.partitions[msg.Partition]
+	if _, ok = p.inFlight[msg.Offset]; !ok {
+		return nil, ErrUnknownMessage
+	}
+
+	return p, nil
+}
+
+// deliver passes messages of the partition to the topic handler one by one.
+func (m *Memory) deliver(t *memoryTopic, p *memoryPartition) {
+	defer m.wg.Done()
+
+	for {
+		msg, handler := m.next(t, p)
+		if msg == nil {
+			select {
+			case <-p.notify:
+				continue
+			case <-m.ctx.Done():
+				return
+			}
+		}
+
+		handler(m.ctx, msg)
+
+		m.handled(p, msg)
+	}
+}
+
+// handled starts ack timeout of the message after handler returns, so long handling doesn't cause
+// redelivery.
+func (m *Memory) handled(p *memoryPartition, msg *Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := p.inFlight[msg.Offset]
+	if ok && d.timer == nil && m.opts.ackTimeout > 0 {
+		m.schedule(p, d, m.opts.ackTimeout)
+	}
+}
+
+// schedule delivers message again after delay unless it's acked.
+func (m *Memory) schedule(p *memoryPartition, d *delivery, delay time.Duration) {
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+
+	d.timer = time.AfterFunc(delay, func() {
+		m.redeliver(p, d)
+	})
+}
+
+// next takes the first pending message of the partition and marks it as waiting for acknowledge.
+func (m *Memory) next(t *memoryTopic, p *memoryPartition) (*Message, Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(p.pending) == 0 || m.ctx.Err() != nil {
+		return nil, nil
+	}
+
+	msg := p.pending[0]
+	p.pending = p.pending[1:]
+
+	p.inFlight[msg.Offset] = &delivery{msg: msg}
+
+	return msg.clone(), t.handler
+}
+
+// redeliver returns message to the pending ones keeping order of offsets.
+func (m *Memory) redeliver(p *memoryPartition, d *delivery) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Message could be acked or delivered again while timer was firing.
+	if p.inFlight[d.msg.Offset] != d {
+		return
+	}
+
+	delete(p.inFlight, d.msg.Offset)
+
+	i, _ := slices.BinarySearchFunc(p.pending, d.msg.Offset, func(pending *Message, offset int64) int {
+		return cmp.Compare(pending.Offset, offset)
+	})
+	p.pending = slices.Insert(p.pending, i, d.msg)
+	p.wakeUp()
+}
+
+// delivery is a message which waits for acknowledge.
+type delivery struct {
+	msg *Message
+	// timer redelivers message, it's set after nack or after handler returns.
+	timer *time.Timer
+}
+
+func (p *memoryPartition) wakeUp() {
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,165 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mc2soft/framework/communication"
+	comContext "github.com/mc2soft/framework/communication/context"
+	errKafka "github.com/mc2soft/framework/errors"
+	defaultrequest "gitlab.local.iti.domain/mc2/golibs/legacy-framework-request"
+)
+
+// Provider adapts communication.Provider of the framework to the Broker. Provider handler waits
+// until delivered message is acked or nacked, nacked message is kept in the topic.
+type Provider struct {
+	provider communication.Provider
+	pending  map[int64]chan bool
+	closed   chan struct{}
+	offset   atomic.Int64
+	once     sync.Once
+	mu       sync.Mutex
+}
+
+// NewProvider creates broker which sends and receives messages by the provider.
+func NewProvider(provider communication.Provider) *Provider {
+	provider.RegisterHandlerNamingFunc(func(_, _, path string) string {
+		return path
+	})
+
+	provider.RegisterDefaultRequestStruct(&defaultrequest.DefaultRequest{})
+
+	return &Provider{
+		provider: provider,
+		pending:  make(map[int64]chan bool),
+		closed:   make(chan struct{}),
+	}
+}
+
+// Publish sends message to the topic, headers of the message are sent as request headers.
+// Provider doesn't support partition keys, so key of the message is ignored.
+func (p *Provider) Publish(ctx context.Context, msg *Message) error {
+	err := p.provider.Send(newRequest(ctx, msg))
+	if err != nil {
+		return fmt.Errorf("send message: %w", err)
+	}
+
+	return nil
+}
+
+// PublishBatch sends messages one by one like Publish does. Asynchronous send of the provider doesn't
+// report failed delivery, so lost messages would be reported as published.
+func (p *Provider) PublishBatch(ctx context.Context, msgs []*Message) []error {
+	errs := make([]error, len(msgs))
+
+	for i, msg := range msgs {
+		errs[i] = p.Publish(ctx, msg)
+	}
+
+	return errs
+}
+
+// newRequest creates request of the message, headers of the message are sent as request headers.
+func newRequest(ctx context.Context, msg *Message) *defaultrequest.DefaultRequest {
+	var headers comContext.Headers
+
+	if len(msg.Headers) > 0 {
+		headers = make(comContext.Headers, len(msg.Headers))
+
+		for key, value := range msg.Headers {
+			headers.Set(key, value)
+		}
+	}
+
+	return defaultrequest.New(ctx, "", msg.Topic, headers, msg.Body)
+}
+
+// Subscribe registers provider handler for the topic.
+func (p *Provider) Subscribe(topic string, handler Handler) error {
+	err := p.provider.RegisterHandler("", topic, func(cctx comContext.Context) error {
+		body, err := io.ReadAll(cctx.Body())
+		if err != nil {
+			return fmt.Errorf("read message: %w", err)
+		}
+
+		ctx := cctx.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		msg := &Message{Topic: topic, Body: body, Offset: p.offset.Add(1)}
+
+		if requestHeaders := cctx.RequestHeaders(); len(requestHeaders) > 0 {
+			msg.Headers = make(map[string]string, len(requestHeaders))
+
+			for key := range requestHeaders {
+				msg.Headers[key] = cctx.GetRequestHeaderValue(key)
+			}
+		}
+		acked := make(chan bool, 1)
+
+		p.mu.Lock()
+		p.pending[msg.Offset] = acked
+		p.mu.Unlock()
+
+		handler(ctx, msg)
+
+		select {
+		case ok := <-acked:
+			if !ok {
+				return errKafka.ErrKafkaDoNotSkipMessage
+			}
+
+			return nil
+		case <-p.closed:
+			p.mu.Lock()
+			delete(p.pending, msg.Offset)
+			p.mu.Unlock()
+
+			return errKafka.ErrKafkaDoNotSkipMessage
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("register handler: %w", err)
+	}
+
+	return nil
+}
+
+// Ack lets provider handler return, so message is committed.
+func (p *Provider) Ack(_ context.Context, msg *Message) error {
+	return p.done(msg, true)
+}
+
+// Nack lets provider handler return error, so message is kept in the topic.
+func (p *Provider) Nack(_ context.Context, msg *Message) error {
+	return p.done(msg, false)
+}
+
+// Close releases handlers which wait for acknowledge, their messages are kept in the topic.
+// Provider itself is stopped by the application.
+func (p *Provider) Close() error {
+	p.once.Do(func() {
+		close(p.closed)
+	})
+
+	return nil
+}
+
+func (p *Provider) done(msg *Message, ok bool) error {
+	p.mu.Lock()
+	acked, found := p.pending[msg.Offset]
+	delete(p.pending, msg.Offset)
+	p.mu.Unlock()
+
+	if !found {
+		return ErrUnknownMessage
+	}
+
+	acked <- ok
+
+	return nil
+}
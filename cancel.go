@@ -0,0 +1,136 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gitlab.local.iti.domain/mc2/golibs/tasks/logger"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+)
+
+// tombstoneTTL is how long cancelled task IDs are remembered.
+const tombstoneTTL = 7 * 24 * time.Hour
+
+// Cancel cancels pending, delayed or retrying task. Task is removed from local queues and
+// tombstone message is published to the topic of the task queue, so consumers skip the task when it
+// arrives. Queue of the task is known by its status, otherwise tombstone is published to topics of all
+// queues. Tombstone is received by one consumer of the group only, so other instances skip the task only
+// if they share status store, it's checked before processing. Without status store cancellation is
+// best-effort. With status store unknown task isn't cancelled, status.ErrNotFound is returned.
+func (t *Tasks) Cancel(ctx context.Context, id string) error {
+	var taskName string
+
+	if t.opts.statusStore != nil {
+		taskStatus, err := t.opts.statusStore.Get(ctx, id)
+		if err != nil {
+			return fmt.Errorf("%w: %w: task_id=%s", ErrCancel, err, id)
+		}
+
+		switch taskStatus.State {
+		case models.TaskStateSucceeded, models.TaskStateDead, models.TaskStateCancelled:
+			return fmt.Errorf("%w: %w: task_id=%s, state=%s", ErrCancel, ErrTaskFinished, id, taskStatus.State)
+		case models.TaskStatePending, models.TaskStateRunning, models.TaskStateRetrying:
+		}
+
+		taskName = taskStatus.Name
+	}
+
+	t.addTombstone(id)
+
+	tombstone := models.Task{
+		ID:        id,
+		StartTime: time.Now().UTC(),
+		Tombstone: true,
+		Version:   models.TaskVersion,
+	}
+
+	for _, topic := range t.tombstoneTopics(taskName) {
+		err := t.publishTo(ctx, topic, tombstone, tombstone)
+		if err != nil {
+			return fmt.Errorf("%w: %w: task_id=%s", ErrCancel, err, id)
+		}
+	}
+
+	t.updateStatus(ctx, models.Task{ID: id}, func(taskStatus *models.TaskStatus) {
+		taskStatus.State = models.TaskStateCancelled
+		taskStatus.FinishedAt = time.Now().UTC()
+		taskStatus.NextRunAt = time.Time{}
+	})
+
+	t.opts.logger.Logf(logger.LogLevelInfo, "task cancelled: %s", map[string]interface{}{"task_id": id}, id)
+
+	return nil
+}
+
+// tombstoneTopics returns topic of the queue of the task, topics of all queues if task name is unknown.
+func (t *Tasks) tombstoneTopics(taskName string) []string {
+	if taskName != "" {
+		return []string{t.topicFor(taskName)}
+	}
+
+	topics := []string{t.opts.topic}
+	for _, q := range t.queues {
+		topics = append(topics, q.topic)
+	}
+
+	return topics
+}
+
+// addTombstone remembers cancelled task and asks retry and delayed workers to drop it.
+func (t *Tasks) addTombstone(id string) {
+	now := time.Now().UTC()
+
+	t.tombstonesMutex.Lock()
+
+	for tombstoneID, cancelledAt := range t.tombstones {
+		if now.Sub(cancelledAt) > tombstoneTTL {
+			delete(t.tombstones, tombstoneID)
+		}
+	}
+
+	t.tombstones[id] = now
+
+	t.tombstonesMutex.Unlock()
+
+	// Workers check tombstones before publishing anyway, so full channels are not a problem.
+	select {
+	case t.cancelRetry <- id:
+	default:
+	}
+
+	select {
+	case t.cancelDelayed <- id:
+	default:
+	}
+}
+
+func (t *Tasks) isCancelled(id string) bool {
+	if id == "" {
+		return false
+	}
+
+	t.tombstonesMutex.RLock()
+	defer t.tombstonesMutex.RUnlock()
+
+	_, ok := t.tombstones[id]
+
+	return ok
+}
+
+// isCancelledInStore reports whether task is cancelled according to status store, e.g. by another
+// instance which received the tombstone. Task is remembered as cancelled then.
+func (t *Tasks) isCancelledInStore(ctx context.Context, id string) bool {
+	if t.opts.statusStore == nil || id == "" {
+		return false
+	}
+
+	taskStatus, err := t.opts.statusStore.Get(ctx, id)
+	if err != nil || taskStatus.State != models.TaskStateCancelled {
+		return false
+	}
+
+	t.addTombstone(id)
+
+	return true
+}
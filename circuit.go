@@ -0,0 +1,76 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"gitlab.local.iti.domain/mc2/golibs/tasks/breaker"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/logger"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+)
+
+// CircuitBreakers returns state of circuit breakers of the handlers ordered by task name.
+func (t *Tasks) CircuitBreakers() []breaker.Stats {
+	t.tasksHandlersMutex.RLock()
+	defer t.tasksHandlersMutex.RUnlock()
+
+	result := make([]breaker.Stats, 0)
+
+	for _, h := range t.tasksHandlers {
+		if h.breaker != nil {
+			result = append(result, h.breaker.Stats())
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	return result
+}
+
+// newCircuitBreaker creates breaker of the task name which logs state changes.
+func (t *Tasks) newCircuitBreaker(taskName string, cfg breaker.Config) *breaker.Breaker {
+	onStateChange := cfg.OnStateChange
+
+	cfg.OnStateChange = func(name string, from, to breaker.State) {
+		level := logger.LogLevelInfo
+		if to == breaker.StateOpen {
+			level = logger.LogLevelError
+		}
+
+		t.opts.logger.Logf(level, "circuit breaker of task %s: %s -> %s",
+			map[string]interface{}{"task_name": name, "from": from, "to": to}, name, from, to)
+
+		if onStateChange != nil {
+			onStateChange(name, from, to)
+		}
+	}
+
+	return breaker.New(taskName, cfg)
+}
+
+// deferTask postpones task while circuit breaker of its handler is open. Attempts are not counted.
+// Task which can't be postponed is dead-lettered like task which can't be retried.
+func (t *Tasks) deferTask(ctx context.Context, task models.Task, wait time.Duration) error {
+	task.StartTime = time.Now().UTC().Add(wait)
+
+	t.opts.logger.Logf(logger.LogLevelDebug, "task deferred by open circuit breaker: %s",
+		map[string]interface{}{"task_name": task.Name, "task_id": task.ID, "wait": wait.String()}, task.Name)
+
+	t.updateStatus(ctx, task, func(taskStatus *models.TaskStatus) {
+		taskStatus.State = models.TaskStatePending
+		taskStatus.NextRunAt = task.StartTime
+	})
+
+	err := t.postpone(task)
+	if err != nil {
+		t.deadLetter(ctx, task, err, models.DeadLetterRetryFailed)
+
+		return fmt.Errorf("%w: defer task: %w: task_name=%s, task_id=%s", errProcessTask, err, task.Name, task.ID)
+	}
+
+	t.blockKey(task)
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package tasks
+
+import "encoding/json"
+
+type createOptions struct {
+	key      string
+	payload  json.RawMessage
+	callback func(id string, err error)
+}
+
+// CreateOption is an interface for task creation options.
+type CreateOption interface {
+	apply(o *createOptions)
+}
+
+func newCreateOptions(opts []CreateOption) *createOptions {
+	co := &createOptions{}
+
+	for _, opt := range opts {
+		opt.apply(co)
+	}
+
+	return co
+}
+
+type payloadOption struct {
+	payload json.RawMessage
+}
+
+func (po *payloadOption) apply(o *createOptions) {
+	o.payload = po.payload
+}
+
+// WithPayload attaches JSON payload to the task. It is delivered to handlers next to params.
+func WithPayload(payload json.RawMessage) CreateOption {
+	return &payloadOption{payload: payload}
+}
+
+type keyOption struct {
+	key string
+}
+
+func (ko *keyOption) apply(o *createOptions) {
+	o.key = ko.key
+}
+
+// WithKey sets partition key of the task. Tasks sharing a key are processed one by one in order of
+// creation, retry of the failed task is processed before the next tasks of the key. Key is kept only by
+// brokers implementing broker.KeyOrderer; send of the framework provider has no key, so broker.NewProvider
+// ignores it and error is logged on creation of the task.
+func WithKey(key string) CreateOption {
+	return &keyOption{key: key}
+}
+
+type callbackOption struct {
+	callback func(id string, err error)
+}
+
+func (co *callbackOption) apply(o *createOptions) {
+	o.callback = co.callback
+}
+
+// WithCallback sets function which is called with ID of the task created by CreateAsync and error of its
+// delivery, nil when the broker confirmed the delivery. It's called from background goroutine before Stop
+// returns. Other create methods ignore the option.
+func WithCallback(callback func(id string, err error)) CreateOption {
+	return &callbackOption{callback: callback}
+}
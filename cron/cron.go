@@ -0,0 +1,157 @@
+// Package cron implements parser of cron expressions used by scheduled tasks.
+//
+// Supported syntax:
+//   - 5 fields: minute hour day-of-month month day-of-week;
+//   - 6 fields: second minute hour day-of-month month day-of-week;
+//   - descriptors: @yearly (@annually), @monthly, @weekly, @daily (@midnight), @hourly, @every <duration>;
+//   - CRON_TZ=<zone> (or TZ=<zone>) prefix sets timezone of the expression.
+//
+// Fields accept "*", "?" (day fields only), lists "1,15", ranges "1-5", steps "*/10", "0-30/5"
+// and month (JAN-DEC) and weekday (SUN-SAT) names. Sunday is both 0 and 7.
+package cron
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrInvalidSpec appears when cron expression can't be parsed.
+var ErrInvalidSpec = errors.New("invalid cron expression")
+
+// searchYears limits search of next activation time for expressions which never match
+// (like "0 0 30 2 *").
+const searchYears = 5
+
+// Schedule describes activation times of scheduled task.
+type Schedule interface {
+	// Next returns first activation time after passed one or zero time if there is none.
+	Next(after time.Time) time.Time
+}
+
+// Every is a schedule with constant period between activations.
+type Every time.Duration
+
+// Next returns time which is period later than passed one.
+func (e Every) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(e))
+}
+
+// SpecSchedule is a schedule parsed from cron expression. Every field is a bit set of
+// allowed values.
+type SpecSchedule struct {
+	Location *time.Location
+	Second   uint64
+	Minute   uint64
+	Hour     uint64
+	Dom      uint64
+	Month    uint64
+	Dow      uint64
+	// DomStar and DowStar tell whether day fields were not restricted. When both day fields
+	// are restricted, day matches if any of them matches.
+	DomStar bool
+	DowStar bool
+}
+
+// Next returns first activation time after passed one or zero time if there is none.
+//
+//nolint:cyclop,gocognit
+func (s *SpecSchedule) Next(after time.Time) time.Time {
+	loc := s.Location
+	if loc == nil {
+		loc = after.Location()
+	}
+
+	origLoc := after.Location()
+
+	t := after.In(loc)
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc).Add(time.Second)
+
+	// truncated tells that smaller units were already reset, so they shouldn't be reset again.
+	truncated := false
+	yearLimit := t.Year() + searchYears
+
+WRAP:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for !bitSet(s.Month, int(t.Month())) {
+		if !truncated {
+			truncated = true
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+		}
+
+		t = t.AddDate(0, 1, 0)
+
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+
+	for !s.dayMatches(t) {
+		if !truncated {
+			truncated = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		}
+
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+
+	for !bitSet(s.Hour, t.Hour()) {
+		if !truncated {
+			truncated = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+		}
+
+		prevDay := t.Day()
+		t = t.Add(time.Hour)
+
+		if t.Day() != prevDay {
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+			goto WRAP
+		}
+	}
+
+	for !bitSet(s.Minute, t.Minute()) {
+		if !truncated {
+			truncated = true
+			t = t.Add(-time.Duration(t.Second()) * time.Second)
+		}
+
+		t = t.Add(time.Minute)
+
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+
+	for !bitSet(s.Second, t.Second()) {
+		truncated = true
+		t = t.Add(time.Second)
+
+		if t.Second() == 0 {
+			goto WRAP
+		}
+	}
+
+	return t.In(origLoc)
+}
+
+func (s *SpecSchedule) dayMatches(t time.Time) bool {
+	domMatch := bitSet(s.Dom, t.Day())
+	dowMatch := bitSet(s.Dow, int(t.Weekday()))
+
+	if s.DomStar || s.DowStar {
+		return domMatch && dowMatch
+	}
+
+	return domMatch || dowMatch
+}
+
+func bitSet(bits uint64, value int) bool {
+	return bits&(1<<uint(value)) != 0 //nolint:gosec
+}
@@ -0,0 +1,77 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNext(t *testing.T) {
+	t.Parallel()
+
+	tashkent, err := time.LoadLocation("Asia/Tashkent")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		spec     string
+		after    string
+		expected string
+	}{
+		{name: "every minute", spec: "* * * * *", after: "2025-03-10T10:15:30Z", expected: "2025-03-10T10:16:00Z"},
+		{name: "weekday at 03:15", spec: "15 3 * * 1-5", after: "2025-03-07T04:00:00Z", expected: "2025-03-10T03:15:00Z"},
+		{name: "weekday names", spec: "15 3 * * MON-FRI", after: "2025-03-08T00:00:00Z", expected: "2025-03-10T03:15:00Z"},
+		{name: "first day of month", spec: "0 0 1 * *", after: "2025-01-31T12:00:00Z", expected: "2025-02-01T00:00:00Z"},
+		{name: "seconds field", spec: "*/20 * * * * *", after: "2025-03-10T10:15:41Z", expected: "2025-03-10T10:16:00Z"},
+		{name: "list and step", spec: "0 0,30 9-17/4 * * *", after: "2025-03-10T09:31:00Z", expected: "2025-03-10T13:00:00Z"},
+		{name: "sunday as 7", spec: "0 12 * * 7", after: "2025-03-10T00:00:00Z", expected: "2025-03-16T12:00:00Z"},
+		{name: "dom or dow", spec: "0 0 13 * 5", after: "2025-03-08T00:00:00Z", expected: "2025-03-13T00:00:00Z"},
+		{name: "leap day", spec: "0 0 29 2 *", after: "2025-01-01T00:00:00Z", expected: "2028-02-29T00:00:00Z"},
+		{name: "daily", spec: "@daily", after: "2025-12-31T23:59:59Z", expected: "2026-01-01T00:00:00Z"},
+		{name: "weekly", spec: "@weekly", after: "2025-03-10T00:00:00Z", expected: "2025-03-16T00:00:00Z"},
+		{name: "every", spec: "@every 90s", after: "2025-03-10T00:00:00Z", expected: "2025-03-10T00:01:30Z"},
+		{name: "timezone", spec: "CRON_TZ=Asia/Tashkent 0 9 * * *", after: "2025-03-10T05:00:00Z", expected: "2025-03-11T04:00:00Z"},
+		{name: "never", spec: "0 0 30 2 *", after: "2025-01-01T00:00:00Z", expected: "0001-01-01T00:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			schedule, err := Parse(tt.spec)
+			require.NoError(t, err)
+
+			after, err := time.Parse(time.RFC3339, tt.after)
+			require.NoError(t, err)
+
+			expected, err := time.Parse(time.RFC3339, tt.expected)
+			require.NoError(t, err)
+
+			require.True(t, expected.Equal(schedule.Next(after)), "got %s", schedule.Next(after))
+		})
+	}
+
+	t.Run("location", func(t *testing.T) {
+		t.Parallel()
+
+		schedule, err := ParseInLocation("0 9 * * *", tashkent)
+		require.NoError(t, err)
+
+		next := schedule.Next(time.Date(2025, 3, 10, 5, 0, 0, 0, time.UTC))
+		require.True(t, time.Date(2025, 3, 11, 9, 0, 0, 0, tashkent).Equal(next))
+	})
+}
+
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, spec := range []string{
+		"", "* * * *", "* * * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *",
+		"* * * * 8", "*/0 * * * *", "5-1 * * * *", "@every", "@every -1s", "@sometimes",
+		"CRON_TZ=Nowhere/Unknown * * * * *", "x * * * *",
+	} {
+		_, err := Parse(spec)
+		require.ErrorIs(t, err, ErrInvalidSpec, spec)
+	}
+}
@@ -0,0 +1,221 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type bounds struct {
+	names    map[string]int
+	min, max int
+}
+
+var (
+	secondBounds = bounds{min: 0, max: 59}
+	minuteBounds = bounds{min: 0, max: 59}
+	hourBounds   = bounds{min: 0, max: 23}
+	domBounds    = bounds{min: 1, max: 31}
+	monthBounds  = bounds{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Day of week allows 7 as an alias of Sunday.
+	dowBounds = bounds{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// Parse parses cron expression. Expression without timezone prefix is evaluated in location
+// of the time passed to Next.
+func Parse(spec string) (Schedule, error) {
+	return ParseInLocation(spec, nil)
+}
+
+// ParseInLocation parses cron expression which is evaluated in passed location unless
+// expression has CRON_TZ= (TZ=) prefix.
+func ParseInLocation(spec string, loc *time.Location) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		zone, rest, _ := strings.Cut(spec, " ")
+		_, name, _ := strings.Cut(zone, "=")
+
+		var err error
+
+		loc, err = time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", ErrInvalidSpec, spec, err)
+		}
+
+		spec = strings.TrimSpace(rest)
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		period, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil || period <= 0 {
+			return nil, fmt.Errorf("%w: %q: invalid duration", ErrInvalidSpec, spec)
+		}
+
+		return Every(period), nil
+	}
+
+	if strings.HasPrefix(spec, "@") {
+		expanded, ok := descriptors[strings.ToLower(spec)]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q: unknown descriptor", ErrInvalidSpec, spec)
+		}
+
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("%w: %q: expected 5 or 6 fields, got %d", ErrInvalidSpec, spec, len(fields))
+	}
+
+	schedule := &SpecSchedule{Location: loc}
+
+	var err error
+
+	for _, field := range []struct {
+		bits   *uint64
+		star   *bool
+		value  string
+		bounds bounds
+	}{
+		{bits: &schedule.Second, value: fields[0], bounds: secondBounds},
+		{bits: &schedule.Minute, value: fields[1], bounds: minuteBounds},
+		{bits: &schedule.Hour, value: fields[2], bounds: hourBounds},
+		{bits: &schedule.Dom, star: &schedule.DomStar, value: fields[3], bounds: domBounds},
+		{bits: &schedule.Month, value: fields[4], bounds: monthBounds},
+		{bits: &schedule.Dow, star: &schedule.DowStar, value: fields[5], bounds: dowBounds},
+	} {
+		var star bool
+
+		*field.bits, star, err = parseField(field.value, field.bounds)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", ErrInvalidSpec, spec, err)
+		}
+
+		if field.star != nil {
+			*field.star = star
+		}
+	}
+
+	// Sunday is both 0 and 7.
+	if bitSet(schedule.Dow, 7) {
+		schedule.Dow |= 1
+	}
+
+	return schedule, nil
+}
+
+// parseField parses comma-separated list of field expressions and reports whether field
+// was unrestricted ("*" or "?").
+func parseField(field string, b bounds) (uint64, bool, error) {
+	var bits uint64
+
+	star := false
+
+	for _, expr := range strings.Split(field, ",") {
+		exprBits, exprStar, err := parseExpr(expr, b)
+		if err != nil {
+			return 0, false, err
+		}
+
+		bits |= exprBits
+		star = star || exprStar
+	}
+
+	return bits, star, nil
+}
+
+// parseExpr parses single field expression: "*", "?", "N", "N-M", any of them followed by "/step".
+func parseExpr(expr string, b bounds) (uint64, bool, error) {
+	rangeExpr, stepExpr, hasStep := strings.Cut(expr, "/")
+
+	start, end := b.min, b.max
+	star := false
+
+	switch {
+	case rangeExpr == "*" || rangeExpr == "?":
+		star = !hasStep
+	default:
+		low, high, isRange := strings.Cut(rangeExpr, "-")
+
+		var err error
+
+		start, err = parseValue(low, b)
+		if err != nil {
+			return 0, false, err
+		}
+
+		end = start
+
+		if isRange {
+			end, err = parseValue(high, b)
+			if err != nil {
+				return 0, false, err
+			}
+		} else if hasStep {
+			end = b.max
+		}
+	}
+
+	step := 1
+
+	if hasStep {
+		var err error
+
+		step, err = strconv.Atoi(stepExpr)
+		if err != nil || step <= 0 {
+			return 0, false, fmt.Errorf("invalid step %q", expr)
+		}
+	}
+
+	if start > end {
+		return 0, false, fmt.Errorf("invalid range %q", expr)
+	}
+
+	var bits uint64
+
+	for value := start; value <= end; value += step {
+		bits |= 1 << uint(value) //nolint:gosec
+	}
+
+	return bits, star, nil
+}
+
+func parseValue(value string, b bounds) (int, error) {
+	if number, ok := b.names[strings.ToLower(value)]; ok {
+		return number, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+
+	if number < b.min || number > b.max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", number, b.min, b.max)
+	}
+
+	return number, nil
+}
@@ -0,0 +1,126 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gitlab.local.iti.domain/mc2/golibs/tasks/logger"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+)
+
+// ListDeadLetters returns dead-lettered tasks matching filter.
+func (t *Tasks) ListDeadLetters(ctx context.Context, filter models.DeadLetterFilter) ([]models.DeadLetter, error) {
+	if t.opts.deadLetterStore == nil {
+		return nil, fmt.Errorf("%w: %w", ErrListDeadLetters, ErrDeadLetterNotSet)
+	}
+
+	letters, err := t.opts.deadLetterStore.List(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrListDeadLetters, err)
+	}
+
+	return letters, nil
+}
+
+// GetDeadLetter returns dead-lettered task by its ID.
+func (t *Tasks) GetDeadLetter(ctx context.Context, id string) (models.DeadLetter, error) {
+	if t.opts.deadLetterStore == nil {
+		return models.DeadLetter{}, fmt.Errorf("%w: %w", ErrGetDeadLetter, ErrDeadLetterNotSet)
+	}
+
+	letter, err := t.opts.deadLetterStore.Get(ctx, id)
+	if err != nil {
+		return models.DeadLetter{}, fmt.Errorf("%w: %w: task_id=%s", ErrGetDeadLetter, err, id)
+	}
+
+	return letter, nil
+}
+
+// Requeue creates dead-lettered task again with the same ID and removes it from dead letters.
+// Attempts counter starts from scratch.
+func (t *Tasks) Requeue(ctx context.Context, id string) error {
+	letter, err := t.GetDeadLetter(ctx, id)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRequeue, err)
+	}
+
+	task := letter.Task
+	task.StartTime = time.Now().UTC()
+	task.History = nil
+	task.RetryDelay = 0
+	task.Meta.Attempt = 1
+	task.Meta.Origin = models.OriginCreate
+	task.Meta.LastError = ""
+
+	// Dead letter is deleted before publishing, so concurrent Requeue of the same task fails.
+	err = t.opts.deadLetterStore.Delete(ctx, id)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRequeue, err)
+	}
+
+	t.updateStatus(ctx, task, func(taskStatus *models.TaskStatus) {
+		taskStatus.State = models.TaskStatePending
+		taskStatus.Attempts = 0
+		taskStatus.LastError = ""
+		taskStatus.FinishedAt = time.Time{}
+	})
+
+	err = t.publish(ctx, task)
+	if err != nil {
+		t.markDead(ctx, task, err)
+
+		// Task isn't created, so it's kept in dead letters.
+		putErr := t.opts.deadLetterStore.Put(ctx, letter)
+		if putErr != nil {
+			t.opts.logger.Logf(logger.LogLevelError, "restore dead letter error: %s",
+				map[string]interface{}{"task_name": task.Name, "task_id": task.ID}, putErr.Error())
+		}
+
+		return fmt.Errorf("%w: %w", ErrRequeue, err)
+	}
+
+	t.opts.logger.Logf(logger.LogLevelInfo, "dead letter requeued: %s",
+		map[string]interface{}{"task_name": task.Name, "task_id": task.ID}, task.Name)
+
+	return nil
+}
+
+// deadLetter marks task as dead and sends it to dead letter topic and store if they are configured.
+func (t *Tasks) deadLetter(ctx context.Context, task models.Task, taskErr error, reason models.DeadLetterReason) {
+	t.markDead(ctx, task, taskErr)
+
+	if t.opts.deadLetterStore == nil {
+		return
+	}
+
+	letter := models.DeadLetter{
+		DeadAt:   time.Now().UTC(),
+		Task:     task,
+		Error:    taskErr.Error(),
+		Reason:   reason,
+		Attempts: task.History,
+	}
+
+	letter.Task.History = nil
+
+	if len(letter.Attempts) > 0 {
+		letter.FirstAttemptAt = letter.Attempts[0].StartedAt
+	}
+
+	fields := map[string]interface{}{"task_name": task.Name, "task_id": task.ID, "reason": reason}
+
+	err := t.opts.deadLetterStore.Put(ctx, letter)
+	if err != nil {
+		t.opts.logger.Logf(logger.LogLevelError, "save dead letter error: %s", fields, err.Error())
+	}
+
+	if t.opts.deadLetterTopic != "" {
+		err = t.publishTo(ctx, t.opts.deadLetterTopic, task, letter)
+		if err != nil {
+			t.opts.logger.Logf(logger.LogLevelError, "publish dead letter error: %s", fields, err.Error())
+		}
+	}
+
+	t.opts.logger.Logf(logger.LogLevelInfo, "task dead-lettered: %s (reason: %s)", fields, task.Name, reason)
+}
@@ -0,0 +1,48 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+)
+
+// ErrNotFound appears when there is no dead letter for requested task.
+var ErrNotFound = errors.New("dead letter not found")
+
+// Store is an interface for storage of dead-lettered tasks which could be provided on initialization.
+type Store interface {
+	// Put saves dead letter, existing dead letter of the same task is replaced.
+	Put(ctx context.Context, letter models.DeadLetter) error
+	// Get returns dead letter by task ID.
+	Get(ctx context.Context, id string) (models.DeadLetter, error)
+	// List returns dead letters matching filter ordered by time of death.
+	List(ctx context.Context, filter models.DeadLetterFilter) ([]models.DeadLetter, error)
+	// Delete removes dead letter by task ID.
+	Delete(ctx context.Context, id string) error
+}
+
+func filterLetters(letters map[string]models.DeadLetter, filter models.DeadLetterFilter) []models.DeadLetter {
+	result := make([]models.DeadLetter, 0)
+
+	for _, letter := range letters {
+		if filter.Match(letter) {
+			result = append(result, letter)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].DeadAt.Equal(result[j].DeadAt) {
+			return result[i].Task.ID < result[j].Task.ID
+		}
+
+		return result[i].DeadAt.Before(result[j].DeadAt)
+	})
+
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[:filter.Limit]
+	}
+
+	return result
+}
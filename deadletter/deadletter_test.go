@@ -0,0 +1,52 @@
+package deadletter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+)
+
+func TestMemoryStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewMemoryStore()
+	now := time.Now().UTC()
+
+	_, err := store.Get(ctx, "unknown")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	for i, id := range []string{"second", "first", "other"} {
+		name := "test"
+		if id == "other" {
+			name = "other"
+		}
+
+		err = store.Put(ctx, models.DeadLetter{
+			Task:   models.Task{ID: id, Name: name},
+			DeadAt: now.Add(-time.Duration(i) * time.Second),
+			Error:  "failed",
+		})
+		require.NoError(t, err)
+	}
+
+	letter, err := store.Get(ctx, "first")
+	require.NoError(t, err)
+	require.Equal(t, "failed", letter.Error)
+
+	letters, err := store.List(ctx, models.DeadLetterFilter{Name: "test"})
+	require.NoError(t, err)
+	require.Len(t, letters, 2)
+	require.Equal(t, "first", letters[0].Task.ID)
+
+	letters, err = store.List(ctx, models.DeadLetterFilter{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	require.Equal(t, "other", letters[0].Task.ID)
+
+	require.NoError(t, store.Delete(ctx, "first"))
+	require.ErrorIs(t, store.Delete(ctx, "first"), ErrNotFound)
+}
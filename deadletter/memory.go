@@ -0,0 +1,64 @@
+package deadletter
+
+import (
+	"context"
+	"sync"
+
+	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+)
+
+// MemoryStore keeps dead letters in process memory.
+type MemoryStore struct {
+	letters map[string]models.DeadLetter
+	mu      sync.RWMutex
+}
+
+// NewMemoryStore creates new in-memory dead letter store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{letters: make(map[string]models.DeadLetter)}
+}
+
+// Put saves dead letter, existing dead letter of the same task is replaced.
+func (m *MemoryStore) Put(_ context.Context, letter models.DeadLetter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.letters[letter.Task.ID] = letter
+
+	return nil
+}
+
+// Get returns dead letter by task ID.
+func (m *MemoryStore) Get(_ context.Context, id string) (models.DeadLetter, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	letter, ok := m.letters[id]
+	if !ok {
+		return models.DeadLetter{}, ErrNotFound
+	}
+
+	return letter, nil
+}
+
+// List returns dead letters matching filter ordered by time of death.
+func (m *MemoryStore) List(_ context.Context, filter models.DeadLetterFilter) ([]models.DeadLetter, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return filterLetters(m.letters, filter), nil
+}
+
+// Delete removes dead letter by task ID.
+func (m *MemoryStore) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.letters[id]; !ok {
+		return ErrNotFound
+	}
+
+	delete(m.letters, id)
+
+	return nil
+}
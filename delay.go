@@ -0,0 +1,180 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"gitlab.local.iti.domain/mc2/golibs/tasks/broker"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/logger"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+)
+
+//nolint:gochecknoglobals
+var (
+	defaultDelayTiers = []time.Duration{
+		time.Second,
+		5 * time.Second,
+		30 * time.Second,
+		time.Minute,
+		5 * time.Minute,
+		30 * time.Minute,
+		time.Hour,
+	}
+	defaultRetryTiers = []time.Duration{
+		time.Second,
+		5 * time.Second,
+		30 * time.Second,
+		time.Minute,
+		5 * time.Minute,
+	}
+)
+
+const (
+	delayTopicKind = "delay"
+	retryTopicKind = "retry"
+
+	// maxDelayWait limits how long delay handler blocks consumer of the partition, message which isn't
+	// due after it is delivered again.
+	maxDelayWait = 10 * time.Second
+)
+
+// delayTiers is a set of topics "<topic>.<kind>.<tier>" where tasks wait until they are due.
+type delayTiers struct {
+	kind  string
+	tiers []time.Duration
+}
+
+// newDelayTiers validates tiers and sorts them in ascending order, it returns nil if tiers are not set.
+func newDelayTiers(kind string, tiers []time.Duration) (*delayTiers, error) {
+	if len(tiers) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+
+	tiers = slices.Clone(tiers)
+	slices.Sort(tiers)
+	tiers = slices.Compact(tiers)
+
+	if tiers[0] <= 0 {
+		return nil, fmt.Errorf("%w: %s %s", ErrInvalidDelayTier, kind, tiers[0])
+	}
+
+	return &delayTiers{kind: kind, tiers: tiers}, nil
+}
+
+// tier returns the largest tier which is not longer than delay.
+func (dt *delayTiers) tier(delay time.Duration) time.Duration {
+	tier := dt.tiers[0]
+
+	for _, candidate := range dt.tiers[1:] {
+		if candidate > delay {
+			break
+		}
+
+		tier = candidate
+	}
+
+	return tier
+}
+
+func (t *Tasks) tierTopic(dt *delayTiers, tier time.Duration) string {
+	return t.opts.topic + "." + dt.kind + "." + tier.String()
+}
+
+// registerDelayHandlers subscribes to delay and retry topics.
+func (t *Tasks) registerDelayHandlers() error {
+	for _, dt := range []*delayTiers{t.delays, t.retries} {
+		if dt == nil {
+			continue
+		}
+
+		for _, tier := range dt.tiers {
+			err := t.subscribe(t.tierTopic(dt, tier), t.delayHandler(dt, tier))
+			if err != nil {
+				return fmt.Errorf("register %s handler %s: %w", dt.kind, tier, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// publishDelayed publishes task with StartTime in the future to topic of the tier.
+func (t *Tasks) publishDelayed(ctx context.Context, dt *delayTiers, task models.Task) error {
+	tier := dt.tier(time.Until(task.StartTime))
+	task.EnqueuedAt = time.Now().UTC()
+
+	return t.publishTo(ctx, t.tierTopic(dt, tier), task, task)
+}
+
+// forwardDelayed moves task to the main topic when it's due or to the next tier otherwise.
+func (t *Tasks) forwardDelayed(ctx context.Context, dt *delayTiers, task models.Task) error {
+	if task.StartTime.After(time.Now().UTC()) {
+		return t.publishDelayed(ctx, dt, task)
+	}
+
+	task.EnqueuedAt = time.Time{}
+
+	return t.publish(ctx, task)
+}
+
+// delayHandler holds message of tier topic until task is due or tier time is over. Messages in the
+// topic are ordered by EnqueuedAt, so blocking the partition doesn't delay the next messages.
+// Message is held for maxDelayWait at most and is delivered again if it isn't due yet, so consumer
+// isn't blocked for the whole tier, e.g. on rebalance. Message is left in the topic on shutdown or
+// when delivery context is done, so delay survives restart.
+func (t *Tasks) delayHandler(dt *delayTiers, tier time.Duration) messageHandler {
+	return func(ctx context.Context, msg *broker.Message) error {
+		var task models.Task
+
+		err := json.Unmarshal(msg.Body, &task)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errHandler, err)
+		}
+
+		task.Upgrade()
+
+		if !t.AreConsumersActive.Load() {
+			return errRedeliver
+		}
+
+		wakeAt := task.EnqueuedAt.Add(tier)
+		if task.StartTime.Before(wakeAt) {
+			wakeAt = task.StartTime
+		}
+
+		wait := time.Until(wakeAt)
+
+		timer := time.NewTimer(min(wait, maxDelayWait))
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return errRedeliver
+		case <-t.stopCtx.Done():
+			return errRedeliver
+		case <-timer.C:
+		}
+
+		if wait > maxDelayWait {
+			return errRedeliver
+		}
+
+		if t.isCancelled(task.ID) {
+			return nil
+		}
+
+		err = t.forwardDelayed(t.opts.ctx, dt, task)
+		if err != nil {
+			t.opts.logger.Logf(logger.LogLevelError, "forward %s task error: %s",
+				map[string]interface{}{"task_name": task.Name, "task_id": task.ID, "tier": tier.String()},
+				dt.kind, err.Error())
+
+			return errRedeliver
+		}
+
+		return nil
+	}
+}
@@ -7,22 +7,84 @@ var (
 	// ErrRegisterHandler указывает на возникновение ошибки при регистрации обработчика задачи.
 	ErrRegisterHandler = errors.New("RegisterHandler method")
 
+	// ErrRegisterHandlerCtx указывает на возникновение ошибки при регистрации обработчика задачи с контекстом.
+	ErrRegisterHandlerCtx = errors.New("RegisterHandlerCtx method")
+
 	// ErrTaskNameAlreadyRegistered указывает на присутствие ранее зарегистрированного обработчика задачи.
 	ErrTaskNameAlreadyRegistered = errors.New("task name already registered")
 
 	// ErrCreate указывает на возникновение ошибки при попытке создать задачу на обработку.
 	ErrCreate = errors.New("Create method")
 
+	// ErrCreateBatch указывает на возникновение ошибки при создании задачи из пакета.
+	ErrCreateBatch = errors.New("CreateBatch method")
+
+	// ErrCreateAsync указывает на возникновение ошибки при асинхронном создании задачи.
+	ErrCreateAsync = errors.New("CreateAsync method")
+
+	// ErrStopped указывает на создание задачи после остановки обработки задач.
+	ErrStopped = errors.New("tasks are stopped")
+
 	ErrUnknownProvider = errors.New("unknown provider")
 	ErrUnknownContext  = errors.New("unknown context")
 
 	ErrTaskNameNotRegistered = errors.New("task name not registered")
 	ErrCreateScheduled       = errors.New("CreateScheduled method")
+	ErrCreateCron            = errors.New("CreateCron method")
+	ErrPauseScheduled        = errors.New("PauseScheduled method")
+	ErrResumeScheduled       = errors.New("ResumeScheduled method")
+	ErrRemoveScheduled       = errors.New("RemoveScheduled method")
+	ErrUpdateSchedule        = errors.New("UpdateSchedule method")
+	ErrScheduledTaskNotFound = errors.New("scheduled task not found")
+	ErrUnknownMisfirePolicy  = errors.New("unknown misfire policy")
+	ErrInvalidPeriod         = errors.New("invalid period")
 	ErrCreateDelayed         = errors.New("CreateDelayed method")
 
-	ErrEmptyTopic = errors.New("empty topic")
+	ErrEmptyTopic       = errors.New("empty topic")
+	ErrInvalidDelayTier = errors.New("invalid delay tier")
+
+	// ErrAckWithoutDurableQueues указывает на подтверждение после обработки без отложенных задач и повторов в топиках.
+	ErrAckWithoutDurableQueues = errors.New("ack after processing requires durable delays and retries")
+
+	// ErrInvalidQueue указывает на некорректную конфигурацию именованной очереди.
+	ErrInvalidQueue = errors.New("invalid queue")
+	// ErrUnknownQueue указывает на привязку обработчика к неизвестной очереди.
+	ErrUnknownQueue = errors.New("unknown queue")
+
+	// ErrCancel указывает на возникновение ошибки при отмене задачи.
+	ErrCancel = errors.New("Cancel method")
+	// ErrTaskFinished указывает на то, что задача уже завершена и не может быть отменена.
+	ErrTaskFinished = errors.New("task already finished")
+
+	// ErrStatus указывает на возникновение ошибки при получении статуса задачи.
+	ErrStatus = errors.New("Status method")
+	// ErrList указывает на возникновение ошибки при получении списка статусов задач.
+	ErrList = errors.New("List method")
+	// ErrStatusStoreNotSet указывает на то, что хранилище статусов не было передано при инициализации.
+	ErrStatusStoreNotSet = errors.New("status store not set")
+
+	// ErrListDeadLetters указывает на возникновение ошибки при получении списка dead letter задач.
+	ErrListDeadLetters = errors.New("ListDeadLetters method")
+	// ErrGetDeadLetter указывает на возникновение ошибки при получении dead letter задачи.
+	ErrGetDeadLetter = errors.New("GetDeadLetter method")
+	// ErrRequeue указывает на возникновение ошибки при повторной постановке dead letter задачи в очередь.
+	ErrRequeue = errors.New("Requeue method")
+	// ErrDeadLetterNotSet указывает на то, что dead letter не был настроен при инициализации.
+	ErrDeadLetterNotSet = errors.New("dead letter not set")
+
+	// ErrDefine указывает на возникновение ошибки при объявлении типизированной задачи.
+	ErrDefine = errors.New("Define method")
+	// ErrEnqueue указывает на возникновение ошибки при постановке типизированной задачи в очередь.
+	ErrEnqueue = errors.New("Enqueue method")
+	// ErrDecodePayload указывает на невозможность разобрать данные типизированной задачи.
+	ErrDecodePayload = errors.New("decode task payload")
 
 	errProcessTask = errors.New("processTask method")
 
 	errHandler = errors.New("handleTask method")
+
+	// errRedeliver is returned by message handler to keep message in the broker.
+	errRedeliver = errors.New("redeliver message")
+	// errAckDeferred is returned by message handler which acknowledges message later.
+	errAckDeferred = errors.New("ack deferred")
 )
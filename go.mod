@@ -5,13 +5,13 @@ go 1.24.0
 replace github.com/mc2soft/framework v0.1.1-0.20250916105655-254d771ad1b2 => gitlab.local.iti.domain/mc2/golibs/framework v0.4.0
 
 require (
+	github.com/IBM/sarama v1.46.0
 	github.com/mc2soft/framework v0.1.1-0.20250916105655-254d771ad1b2
 	github.com/stretchr/testify v1.11.0
 	gitlab.local.iti.domain/mc2/golibs/legacy-framework-request v1.0.0
 )
 
 require (
-	github.com/IBM/sarama v1.46.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/eapache/go-resiliency v1.7.0 // indirect
 	github.com/eapache/go-xerial-snappy v0.0.0-20230731223053-c322873962e3 // indirect
@@ -1,27 +1,208 @@
 package tasks
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
-	comContext "github.com/mc2soft/framework/communication/context"
-	errKafka "github.com/mc2soft/framework/errors"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/broker"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/logger"
 	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
 )
 
-func (t *Tasks) handleTask(ctx comContext.Context) error {
+// messageHandler handles message of the broker. Message is kept in the broker if handler returns errRedeliver
+// and it isn't acknowledged by subscription if handler returns errAckDeferred.
+type messageHandler func(ctx context.Context, msg *broker.Message) error
+
+// queuedTask is a task waiting for worker.
+type queuedTask struct {
+	// msg is a message of the task which is acked after processing, it's nil if message is acked already.
+	msg  *broker.Message
+	task models.Task
+	// wake is set when block of the task key is expired, so parked tasks of the key are resumed.
+	wake bool
+}
+
+// subscribe subscribes handler to the topic and acknowledges handled messages.
+func (t *Tasks) subscribe(topic string, handler messageHandler) error {
+	return t.broker.Subscribe(topic, func(ctx context.Context, msg *broker.Message) {
+		err := handler(ctx, msg)
+
+		switch {
+		case errors.Is(err, errAckDeferred):
+		case errors.Is(err, errRedeliver):
+			err = t.broker.Nack(ctx, msg)
+			if err != nil {
+				t.opts.logger.Logf(logger.LogLevelError, "nack message error: %s",
+					map[string]interface{}{"topic": topic}, err.Error())
+			}
+		case err != nil:
+			t.opts.logger.Logf(logger.LogLevelError, "handle message error: %s",
+				map[string]interface{}{"topic": topic}, err.Error())
+
+			t.ack(ctx, msg)
+		default:
+			t.ack(ctx, msg)
+		}
+	})
+}
+
+// ack acknowledges handled message.
+func (t *Tasks) ack(ctx context.Context, msg *broker.Message) {
+	err := t.broker.Ack(ctx, msg)
+	if err != nil {
+		t.opts.logger.Logf(logger.LogLevelError, "ack message error: %s",
+			map[string]interface{}{"topic": msg.Topic}, err.Error())
+	}
+}
+
+func (t *Tasks) handleTask(_ context.Context, msg *broker.Message) error {
+	if !t.AreConsumersActive.Load() {
+		return errRedeliver
+	}
+
+	if t.skipMessage(msg) {
+		return nil
+	}
+
 	var task models.Task
 
-	err := json.NewDecoder(ctx.Body()).Decode(&task)
+	err := json.Unmarshal(msg.Body, &task)
 	if err != nil {
 		return fmt.Errorf("%w: %w", errHandler, err)
 	}
 
-	if !t.AreConsumersActive.Load() {
-		return errKafka.ErrKafkaDoNotSkipMessage
+	// Messages published by previous versions keep metadata in params.
+	task.Upgrade()
+
+	if task.Tombstone {
+		t.addTombstone(task.ID)
+		return nil
+	}
+
+	// Task is not due yet, e.g. it was published by another instance on shutdown.
+	if task.StartTime.After(time.Now().UTC()) {
+		return t.postpone(task)
+	}
+
+	if !t.opts.ackAfterProcessing {
+		if !t.enqueue(queuedTask{task: task}) {
+			return errRedeliver
+		}
+
+		return nil
+	}
+
+	if !t.enqueue(queuedTask{msg: msg, task: task}) {
+		return errRedeliver
+	}
+
+	return errAckDeferred
+}
+
+// enqueue passes task to workers. It returns false if workers are stopped, so message is delivered again.
+func (t *Tasks) enqueue(queued queuedTask) bool {
+	// Queues are closed by Stop only after senders which are in progress are done.
+	t.queuesMutex.RLock()
+	defer t.queuesMutex.RUnlock()
+
+	if t.queuesClosed {
+		return false
 	}
 
-	t.taskQueue <- task
+	select {
+	case t.queueFor(queued.task) <- queued:
+		return true
+	case <-t.opts.ctx.Done():
+		return false
+	}
+}
+
+// skipMessage reports whether message should be skipped without decoding: task has no handler in this
+// instance or it's cancelled. Messages without headers are decoded.
+func (t *Tasks) skipMessage(msg *broker.Message) bool {
+	name, id := msg.Headers[HeaderTaskName], msg.Headers[HeaderTaskID]
+
+	if name != "" {
+		t.tasksHandlersMutex.RLock()
+		_, ok := t.tasksHandlers[name]
+		t.tasksHandlersMutex.RUnlock()
+
+		if !ok {
+			// Task is lost for this instance, it's expected only if topic is shared on purpose.
+			level := logger.LogLevelError
+			if t.opts.skipUnknownTasks {
+				level = logger.LogLevelDebug
+			}
+
+			t.opts.logger.Logf(level, "skipping task without handler: %s",
+				map[string]interface{}{"task_name": name, "task_id": id}, name)
+
+			return true
+		}
+	}
+
+	if id != "" && t.isCancelled(id) {
+		t.opts.logger.Logf(logger.LogLevelInfo, "skipping cancelled task: %s",
+			map[string]interface{}{"task_name": name, "task_id": id}, name)
+
+		return true
+	}
+
+	return false
+}
+
+// postpone puts task which is not due yet to delay or retry topic or to in-memory delayed queue.
+func (t *Tasks) postpone(task models.Task) error {
+	dt := t.delays
+	if dt == nil && task.Meta.Origin == models.OriginRetry {
+		dt = t.retries
+	}
+
+	if dt == nil {
+		// Delayed queue is closed by Stop only after senders which are in progress are done.
+		t.queuesMutex.RLock()
+		defer t.queuesMutex.RUnlock()
+
+		if t.delayedClosed {
+			return fmt.Errorf("%w: %w", errRedeliver, ErrStopped)
+		}
+
+		// Delayed task worker reads the queue till application context is done.
+		select {
+		case t.delayedQueue <- task:
+			return nil
+		case <-t.opts.ctx.Done():
+			return fmt.Errorf("%w: %w", errRedeliver, t.opts.ctx.Err())
+		}
+	}
+
+	err := t.publishDelayed(t.opts.ctx, dt, task)
+	if err != nil {
+		t.opts.logger.Logf(logger.LogLevelError, "postpone task error: %s",
+			map[string]interface{}{"task_name": task.Name, "task_id": task.ID}, err.Error())
+
+		return fmt.Errorf("%w: %w", errRedeliver, err)
+	}
 
 	return nil
 }
+
+// queueFor returns queue of the dedicated pool of the task handler or of the common pool.
+func (t *Tasks) queueFor(task models.Task) chan queuedTask {
+	t.tasksHandlersMutex.RLock()
+	defer t.tasksHandlersMutex.RUnlock()
+
+	h, ok := t.tasksHandlers[task.Name]
+	if ok && h.pool != nil {
+		return h.pool.dispatch(task)
+	}
+
+	if ok && h.queue != nil {
+		return h.queue.pool.dispatch(task)
+	}
+
+	return t.pool.dispatch(task)
+}
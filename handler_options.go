@@ -0,0 +1,132 @@
+package tasks
+
+import (
+	"time"
+
+	"gitlab.local.iti.domain/mc2/golibs/tasks/breaker"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+)
+
+// taskHandler is a registered handler of the task with its options.
+type taskHandler struct {
+	handler     TaskHandlerCtx
+	opts        *handlerOptions
+	retryPolicy models.RetryPolicy
+	timeout     time.Duration
+	// pool is a dedicated queue of the handler with own workers, nil if handler uses common workers.
+	pool *workerPool
+	// queue is a named queue of the handler, nil if handler is bound to the main topic.
+	queue   *namedQueue
+	breaker *breaker.Breaker
+}
+
+type handlerOptions struct {
+	retryIf     func(err error) bool
+	retryPolicy *models.RetryPolicy
+	timeout     time.Duration
+	breaker     *breaker.Config
+	queue       string
+	concurrency int
+	queueSize   int
+}
+
+// HandlerOption is an interface for options of the task handler.
+type HandlerOption interface {
+	apply(o *handlerOptions)
+}
+
+func newHandlerOptions(opts []HandlerOption) *handlerOptions {
+	ho := &handlerOptions{}
+
+	for _, opt := range opts {
+		opt.apply(ho)
+	}
+
+	return ho
+}
+
+type retryIfOption struct {
+	predicate func(err error) bool
+}
+
+func (ro *retryIfOption) apply(o *handlerOptions) {
+	o.retryIf = ro.predicate
+}
+
+// RetryIf sets predicate which decides whether failed task should be retried. Errors wrapped
+// with Permanent are never retried regardless of the predicate.
+func RetryIf(predicate func(err error) bool) HandlerOption {
+	return &retryIfOption{predicate: predicate}
+}
+
+type handlerRetryOption struct {
+	retryPolicy models.RetryPolicy
+}
+
+func (ro *handlerRetryOption) apply(o *handlerOptions) {
+	o.retryPolicy = &ro.retryPolicy
+}
+
+// WithHandlerRetry sets retry policy of the handler instead of the one passed by WithRetryPolicy.
+// Empty fields of the policy get the same defaults.
+func WithHandlerRetry(retryPolicy models.RetryPolicy) HandlerOption {
+	return &handlerRetryOption{retryPolicy: retryPolicy}
+}
+
+type handlerTimeoutOption struct {
+	timeout time.Duration
+}
+
+func (to *handlerTimeoutOption) apply(o *handlerOptions) {
+	o.timeout = to.timeout
+}
+
+// WithHandlerTimeout sets deadline of the handler execution instead of the one passed by WithExecutionTimeout.
+func WithHandlerTimeout(timeout time.Duration) HandlerOption {
+	return &handlerTimeoutOption{timeout: timeout}
+}
+
+type handlerConcurrencyOption struct {
+	concurrency int
+	queueSize   int
+}
+
+func (co *handlerConcurrencyOption) apply(o *handlerOptions) {
+	o.concurrency = co.concurrency
+	o.queueSize = co.queueSize
+}
+
+// WithHandlerConcurrency makes tasks of the handler processed by own n workers with own queue, so slow
+// handler doesn't occupy common workers. Optional queueSize defaults to the one passed by WithQueueSize.
+func WithHandlerConcurrency(n int, queueSize ...int) HandlerOption {
+	co := &handlerConcurrencyOption{concurrency: n}
+	if len(queueSize) > 0 {
+		co.queueSize = queueSize[0]
+	}
+
+	return co
+}
+
+type circuitBreakerOption struct {
+	cfg breaker.Config
+}
+
+func (co *circuitBreakerOption) apply(o *handlerOptions) {
+	o.breaker = &co.cfg
+}
+
+// WithCircuitBreaker enables circuit breaker for the task name. While breaker is open tasks are
+// deferred till it allows trial execution instead of being executed and retried. Errors wrapped
+// with Permanent don't count as failures.
+func WithCircuitBreaker(cfg breaker.Config) HandlerOption {
+	return &circuitBreakerOption{cfg: cfg}
+}
+
+// shouldRetry reports whether task which failed with err should be retried.
+func (ho *handlerOptions) shouldRetry(err error) bool {
+	if IsPermanent(err) {
+		return false
+	}
+
+	return ho.retryIf == nil || ho.retryIf(err)
+}
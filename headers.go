@@ -0,0 +1,40 @@
+package tasks
+
+import (
+	"strconv"
+
+	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+)
+
+// Headers of the task message. They duplicate task metadata, so consumers and other tools could route
+// and filter messages without decoding the body.
+const (
+	HeaderTaskName    = "x-task-name"
+	HeaderTaskID      = "x-task-id"
+	HeaderTaskAttempt = "x-task-attempt"
+	HeaderTaskVersion = "x-task-version"
+	// HeaderTraceParent is a W3C trace context of the task.
+	HeaderTraceParent = "traceparent"
+)
+
+// taskHeaders returns message headers of the task, empty values are omitted.
+func taskHeaders(task models.Task) map[string]string {
+	headers := map[string]string{
+		HeaderTaskID:      task.ID,
+		HeaderTaskVersion: strconv.Itoa(task.Version),
+	}
+
+	if task.Name != "" {
+		headers[HeaderTaskName] = task.Name
+	}
+
+	if task.Meta.Attempt > 0 {
+		headers[HeaderTaskAttempt] = strconv.Itoa(task.Meta.Attempt)
+	}
+
+	if task.Meta.TraceParent != "" {
+		headers[HeaderTraceParent] = task.Meta.TraceParent
+	}
+
+	return headers
+}
@@ -0,0 +1,19 @@
+package tasks
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// newTaskID generates random (version 4) UUID used as task identifier.
+func newTaskID() string {
+	var id [16]byte
+
+	// rand.Read never returns an error.
+	_, _ = rand.Read(id[:])
+
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
@@ -0,0 +1,282 @@
+package lease
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Kafka keeps leases in compacted Kafka topic. Claim is written as a message keyed by lease name,
+// all instances read the topic and apply claims in partition order, so they agree on the owner
+// without any coordinator. Claims which would be rejected and releases by non-owners are not
+// written, so the last message of the lease kept by compaction is a claim of the owner. Clocks of
+// the instances should be synchronized with precision much better than lease TTL.
+type Kafka struct {
+	client     sarama.Client
+	producer   sarama.SyncProducer
+	consumer   sarama.Consumer
+	consumers  []sarama.PartitionConsumer
+	partitions []int32
+	leases     map[string]Record
+	offsets    map[int32]int64
+	changed    chan struct{}
+	closed     chan struct{}
+	topic      string
+	wg         sync.WaitGroup
+	mu         sync.Mutex
+	closeOnce  sync.Once
+}
+
+// NewKafka creates lease backend on top of the topic, which should be created with
+// cleanup.policy=compact. Existing leases are read from the beginning of the topic.
+func NewKafka(brokers []string, config *sarama.Config, topic string) (*Kafka, error) {
+	if config == nil {
+		config = sarama.NewConfig()
+	}
+
+	cfg := *config
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+
+	client, err := sarama.NewClient(brokers, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("kafka lease: %w", err)
+	}
+
+	k, err := newKafkaFromClient(client, topic)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func newKafkaFromClient(client sarama.Client, topic string) (*Kafka, error) {
+	k := &Kafka{
+		client:  client,
+		leases:  make(map[string]Record),
+		offsets: make(map[int32]int64),
+		changed: make(chan struct{}),
+		closed:  make(chan struct{}),
+		topic:   topic,
+	}
+
+	var err error
+
+	k.producer, err = sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("kafka lease: producer: %w", err)
+	}
+
+	k.consumer, err = sarama.NewConsumerFromClient(client)
+	if err != nil {
+		_ = k.producer.Close()
+		return nil, fmt.Errorf("kafka lease: consumer: %w", err)
+	}
+
+	partitions, err := k.consumer.Partitions(topic)
+	if err != nil {
+		_ = k.Close()
+		return nil, fmt.Errorf("kafka lease: partitions: %w", err)
+	}
+
+	for _, partition := range partitions {
+		pc, err := k.consumer.ConsumePartition(topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			_ = k.Close()
+			return nil, fmt.Errorf("kafka lease: consume partition %d: %w", partition, err)
+		}
+
+		k.consumers = append(k.consumers, pc)
+		k.partitions = append(k.partitions, partition)
+		k.wg.Add(1)
+
+		go k.consume(pc)
+	}
+
+	return k, nil
+}
+
+// Acquire reads the topic up to its end and writes claim of the lease if it's free, expired or
+// owned by holder. Then it waits until the claim is applied and reports whether holder owns the lease.
+func (k *Kafka) Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	current, err := k.current(ctx, name)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now().UTC()
+	claim := Record{Holder: holder, ClaimedAt: now, ExpiresAt: now.Add(ttl)}
+
+	if _, ok := apply(current, claim); !ok {
+		return false, nil
+	}
+
+	partition, offset, err := k.write(name, claim)
+	if err != nil {
+		return false, err
+	}
+
+	if err = k.waitApplied(ctx, partition, offset); err != nil {
+		return false, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	record := k.leases[name]
+
+	return record.Holder == holder && time.Now().UTC().Before(record.ExpiresAt), nil
+}
+
+// Release writes release of the lease if holder owns it.
+func (k *Kafka) Release(ctx context.Context, name, holder string) error {
+	current, err := k.current(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if current.Holder != holder {
+		return nil
+	}
+
+	_, _, err = k.write(name, Record{Holder: holder, Released: true})
+
+	return err
+}
+
+// current returns state of the lease after all messages which are in the topic now are applied.
+func (k *Kafka) current(ctx context.Context, name string) (Record, error) {
+	select {
+	case <-k.closed:
+		return Record{}, ErrClosed
+	default:
+	}
+
+	for _, partition := range k.partitions {
+		newest, err := k.client.GetOffset(k.topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return Record{}, fmt.Errorf("kafka lease: offset of partition %d: %w", partition, err)
+		}
+
+		if err = k.waitApplied(ctx, partition, newest-1); err != nil {
+			return Record{}, err
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.leases[name], nil
+}
+
+// Close stops reading the topic and closes Kafka connections.
+func (k *Kafka) Close() error {
+	var err error
+
+	k.closeOnce.Do(func() {
+		close(k.closed)
+
+		for _, pc := range k.consumers {
+			pc.AsyncClose()
+		}
+
+		k.wg.Wait()
+
+		err = k.consumer.Close()
+
+		if perr := k.producer.Close(); perr != nil && err == nil {
+			err = perr
+		}
+
+		if cerr := k.client.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	})
+
+	if err != nil {
+		return fmt.Errorf("kafka lease: close: %w", err)
+	}
+
+	return nil
+}
+
+func (k *Kafka) write(name string, record Record) (int32, int64, error) {
+	select {
+	case <-k.closed:
+		return 0, 0, ErrClosed
+	default:
+	}
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		return 0, 0, fmt.Errorf("kafka lease: marshal: %w", err)
+	}
+
+	partition, offset, err := k.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: k.topic,
+		Key:   sarama.StringEncoder(name),
+		Value: sarama.ByteEncoder(value),
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("kafka lease: send: %w", err)
+	}
+
+	return partition, offset, nil
+}
+
+func (k *Kafka) waitApplied(ctx context.Context, partition int32, offset int64) error {
+	for {
+		k.mu.Lock()
+		applied := k.offsets[partition] > offset
+		changed := k.changed
+		k.mu.Unlock()
+
+		if applied {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-k.closed:
+			return ErrClosed
+		case <-ctx.Done():
+			return fmt.Errorf("kafka lease: %w", ctx.Err())
+		}
+	}
+}
+
+func (k *Kafka) consume(pc sarama.PartitionConsumer) {
+	defer k.wg.Done()
+
+	for msg := range pc.Messages() {
+		var claim Record
+
+		// Messages which can't be decoded (including compaction tombstones) are skipped.
+		if msg.Value != nil && json.Unmarshal(msg.Value, &claim) == nil {
+			name := string(msg.Key)
+
+			k.mu.Lock()
+
+			record, _ := apply(k.leases[name], claim)
+			if record.Holder == "" {
+				delete(k.leases, name)
+			} else {
+				k.leases[name] = record
+			}
+
+			k.mu.Unlock()
+		}
+
+		k.mu.Lock()
+		k.offsets[msg.Partition] = msg.Offset + 1
+		close(k.changed)
+		k.changed = make(chan struct{})
+		k.mu.Unlock()
+	}
+}
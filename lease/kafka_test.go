@@ -0,0 +1,205 @@
+package lease
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKafka(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().UTC()
+	valid := Record{ClaimedAt: now, ExpiresAt: now.Add(time.Hour)}
+	expired := Record{ClaimedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)}
+
+	// Claims of other instances which are already in the topic.
+	mockBroker := newMockKafka(t, []claim{
+		{name: "scheduler", record: withHolder(valid, "first")},
+		// Lease is owned by the first holder, claim is rejected.
+		{name: "scheduler", record: withHolder(valid, "second")},
+		{name: "reports", record: withHolder(expired, "second")},
+		// Messages which can't be decoded are skipped.
+		{name: "reports"},
+		// Expired lease is taken over.
+		{name: "reports", record: withHolder(valid, "first")},
+		{name: "cleanup", record: withHolder(valid, "first")},
+		{name: "cleanup", record: Record{Holder: "first", Released: true}},
+	})
+	defer mockBroker.Close()
+
+	backend, err := NewKafka([]string{mockBroker.Addr()}, nil, "leases")
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		backend.mu.Lock()
+		defer backend.mu.Unlock()
+
+		return backend.offsets[0] == 7
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, map[string]Record{
+		"scheduler": withHolder(valid, "first"),
+		"reports":   withHolder(valid, "first"),
+	}, backend.leases)
+
+	ctx := context.Background()
+
+	ok, err := backend.Acquire(ctx, "scheduler", "first", time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok, "owner renews lease")
+
+	ok, err = backend.Acquire(ctx, "scheduler", "second", time.Minute)
+	require.NoError(t, err)
+	require.False(t, ok, "lease is owned by first holder")
+
+	require.NoError(t, backend.Release(ctx, "scheduler", "second"))
+	require.NoError(t, backend.Release(ctx, "scheduler", "first"))
+
+	// Only renewal and release of the owner are written to the topic.
+	require.Equal(t, 2, produced(mockBroker))
+
+	require.NoError(t, backend.Close())
+	require.NoError(t, backend.Close())
+
+	_, err = backend.Acquire(ctx, "scheduler", "first", time.Minute)
+	require.ErrorIs(t, err, ErrClosed)
+}
+
+func TestKafkaAcquireContext(t *testing.T) {
+	t.Parallel()
+
+	// Claim is never read back, so Acquire waits until context is done.
+	mockBroker := newMockKafka(t, nil)
+	defer mockBroker.Close()
+
+	backend, err := NewKafka([]string{mockBroker.Addr()}, nil, "leases")
+	require.NoError(t, err)
+
+	defer backend.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err = backend.Acquire(ctx, "scheduler", "first", time.Minute)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestKafkaCompaction(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().UTC()
+	owned := Record{Holder: "first", ClaimedAt: now, ExpiresAt: now.Add(time.Hour)}
+
+	history := []claim{
+		{name: "scheduler", record: owned},
+		{name: "reports", record: owned},
+	}
+
+	mockBroker := newMockKafka(t, history)
+	defer mockBroker.Close()
+
+	backend, err := NewKafka([]string{mockBroker.Addr()}, nil, "leases")
+	require.NoError(t, err)
+
+	defer backend.Close()
+
+	ctx := context.Background()
+
+	// Rejected claim and release by non-owner are not written, otherwise they would be the last
+	// messages of the leases kept by compaction.
+	ok, err := backend.Acquire(ctx, "scheduler", "second", time.Minute)
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.NoError(t, backend.Release(ctx, "reports", "second"))
+	require.Zero(t, produced(mockBroker))
+
+	// Instance which starts after compaction replays the last messages and agrees on the owners.
+	compacted := make(map[string]claim)
+	for _, c := range history {
+		compacted[c.name] = c
+	}
+
+	replayed := newMockKafka(t, slices.Collect(maps.Values(compacted)))
+	defer replayed.Close()
+
+	newcomer, err := NewKafka([]string{replayed.Addr()}, nil, "leases")
+	require.NoError(t, err)
+
+	defer newcomer.Close()
+
+	for _, name := range []string{"scheduler", "reports"} {
+		ok, err = newcomer.Acquire(ctx, name, "third", time.Minute)
+		require.NoError(t, err)
+		require.False(t, ok, "lease %s is owned by first holder", name)
+	}
+
+	require.Zero(t, produced(replayed))
+}
+
+// produced returns amount of messages written to the broker.
+func produced(mockBroker *sarama.MockBroker) int {
+	written := 0
+
+	for _, rr := range mockBroker.History() {
+		if _, ok := rr.Request.(*sarama.ProduceRequest); ok {
+			written++
+		}
+	}
+
+	return written
+}
+
+// claim is a message of the lease topic, message with empty record can't be decoded.
+type claim struct {
+	name   string
+	record Record
+}
+
+func withHolder(record Record, holder string) Record {
+	record.Holder = holder
+
+	return record
+}
+
+// newMockKafka returns broker with single partition of the leases topic which contains claims.
+func newMockKafka(t *testing.T, claims []claim) *sarama.MockBroker {
+	t.Helper()
+
+	mockBroker := sarama.NewMockBroker(t, 1)
+
+	fetch := sarama.NewMockFetchResponse(t, 10).SetHighWaterMark("leases", 0, int64(len(claims)))
+
+	for i, c := range claims {
+		value := []byte("not a claim")
+
+		if c.record.Holder != "" {
+			var err error
+
+			value, err = json.Marshal(c.record)
+			require.NoError(t, err)
+		}
+
+		fetch.SetMessageWithKey("leases", 0, int64(i), sarama.StringEncoder(c.name), sarama.ByteEncoder(value))
+	}
+
+	mockBroker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t),
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(mockBroker.Addr(), mockBroker.BrokerID()).
+			SetLeader("leases", 0, mockBroker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("leases", 0, sarama.OffsetOldest, 0).
+			SetOffset("leases", 0, sarama.OffsetNewest, int64(len(claims))),
+		"FetchRequest":   fetch,
+		"ProduceRequest": sarama.NewMockProduceResponse(t),
+	})
+
+	return mockBroker
+}
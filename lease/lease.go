@@ -0,0 +1,47 @@
+// Package lease provides leases which are used to elect single instance among replicas.
+package lease
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrClosed appears when backend is used after Close.
+var ErrClosed = errors.New("lease backend closed")
+
+// Backend is an interface for lease storage. Lease is owned by one holder until it expires
+// or is released, after that any other holder can acquire it.
+type Backend interface {
+	// Acquire acquires lease for holder or renews it if holder already owns it. Returns true
+	// if holder owns the lease for ttl from now.
+	Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	// Release releases lease if it's owned by holder.
+	Release(ctx context.Context, name, holder string) error
+}
+
+// Record is a state of the lease.
+type Record struct {
+	ClaimedAt time.Time `json:"claimed_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Holder    string    `json:"holder"`
+	Released  bool      `json:"released,omitempty"`
+}
+
+// apply returns lease state after claim. Claim wins if lease is free, expired at the time of
+// the claim or already owned by the claimer.
+func apply(current, claim Record) (Record, bool) {
+	if claim.Released {
+		if current.Holder == claim.Holder {
+			return Record{}, true
+		}
+
+		return current, false
+	}
+
+	if current.Holder == "" || current.Holder == claim.Holder || !claim.ClaimedAt.Before(current.ExpiresAt) {
+		return claim, true
+	}
+
+	return current, false
+}
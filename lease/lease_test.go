@@ -0,0 +1,47 @@
+package lease
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	backend := NewMemory()
+
+	ok, err := backend.Acquire(ctx, "scheduler", "first", 100*time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = backend.Acquire(ctx, "scheduler", "second", 100*time.Millisecond)
+	require.NoError(t, err)
+	require.False(t, ok, "lease is owned by first holder")
+
+	ok, err = backend.Acquire(ctx, "scheduler", "first", 100*time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, ok, "owner renews lease")
+
+	// Owner died, lease fails over after expiration.
+	time.Sleep(150 * time.Millisecond)
+
+	ok, err = backend.Acquire(ctx, "scheduler", "second", time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	require.NoError(t, backend.Release(ctx, "scheduler", "first"))
+
+	ok, err = backend.Acquire(ctx, "scheduler", "first", time.Minute)
+	require.NoError(t, err)
+	require.False(t, ok, "release by non-owner has no effect")
+
+	require.NoError(t, backend.Release(ctx, "scheduler", "second"))
+
+	ok, err = backend.Acquire(ctx, "scheduler", "first", time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
@@ -0,0 +1,46 @@
+package lease
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory keeps leases in process memory. It's useful for tests and single process setups.
+type Memory struct {
+	leases map[string]Record
+	mu     sync.Mutex
+}
+
+// NewMemory creates new in-memory lease backend.
+func NewMemory() *Memory {
+	return &Memory{leases: make(map[string]Record)}
+}
+
+// Acquire acquires lease for holder or renews it if holder already owns it.
+func (m *Memory) Acquire(_ context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	record, ok := apply(m.leases[name], Record{Holder: holder, ClaimedAt: now, ExpiresAt: now.Add(ttl)})
+	m.leases[name] = record
+
+	return ok, nil
+}
+
+// Release releases lease if it's owned by holder.
+func (m *Memory) Release(_ context.Context, name, holder string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, ok := apply(m.leases[name], Record{Holder: holder, Released: true})
+	if ok {
+		delete(m.leases, name)
+	} else {
+		m.leases[name] = record
+	}
+
+	return nil
+}
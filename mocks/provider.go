@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"io"
+	"sync"
 
 	"github.com/mc2soft/framework/base/provider"
 	"github.com/mc2soft/framework/communication"
@@ -17,12 +18,71 @@ var errNotFound = errors.New("handler not found")
 
 type MockProvider struct {
 	handlers map[string]communication.HandlerFunc
+	async    *asyncDelivery
 }
 
 func New() MockProvider {
 	return MockProvider{handlers: make(map[string]communication.HandlerFunc)}
 }
 
+// NewAsync returns provider which calls handlers in background like real broker does: messages of
+// the same path are handled one by one in order of sending. Handler errors are ignored.
+func NewAsync() MockProvider {
+	return MockProvider{
+		handlers: make(map[string]communication.HandlerFunc),
+		async:    &asyncDelivery{queues: make(map[string]chan request.Request)},
+	}
+}
+
+const asyncQueueSize = 1000
+
+type asyncDelivery struct {
+	queues map[string]chan request.Request
+	mu     sync.Mutex
+}
+
+func (a *asyncDelivery) deliver(handler communication.HandlerFunc, req request.Request) {
+	a.mu.Lock()
+
+	queue, ok := a.queues[req.GetPath()]
+	if !ok {
+		queue = make(chan request.Request, asyncQueueSize)
+		a.queues[req.GetPath()] = queue
+
+		go func() {
+			for req := range queue {
+				cctx, err := newContext(req)
+				if err != nil {
+					continue
+				}
+
+				_ = handler(cctx)
+			}
+		}()
+	}
+
+	a.mu.Unlock()
+
+	queue <- req
+}
+
+// newContext returns context of the handler with body and headers of the request.
+func newContext(req request.Request) (*comcontext.DefaultContext, error) {
+	data, err := req.GetData().MarshalJSON()
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	cctx := comcontext.NewDefaultContext()
+	cctx.SetBody(io.NopCloser(bytes.NewReader(data)))
+
+	if req.GetHeaders() != nil {
+		cctx.SetRequestHeaders(req.GetHeaders())
+	}
+
+	return cctx, nil
+}
+
 func (m MockProvider) BaseProviderInitialize() {
 	// TODO implement me
 	panic("implement me")
@@ -84,7 +144,25 @@ func (m MockProvider) IsServer() bool {
 	return false
 }
 
+// SendAsync delivers request like Send does, but ignores handler error like real provider does.
 func (m MockProvider) SendAsync(request request.Request) error {
+	handler, ok := m.handlers[request.GetPath()]
+	if !ok {
+		return errNotFound
+	}
+
+	if m.async != nil {
+		m.async.deliver(handler, request)
+		return nil
+	}
+
+	cctx, err := newContext(request)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	_ = handler(cctx)
+
 	return nil
 }
 
@@ -113,43 +191,36 @@ func (m MockProvider) Request(request request.Request) (*response.Response, erro
 
 //nolint:wrapcheck
 func (m MockProvider) Send(request request.Request) error {
-	cctx := comcontext.NewDefaultContext()
-
 	handler, ok := m.handlers[request.GetPath()]
 	if !ok {
 		return errNotFound
 	}
 
-	data, err := request.GetData().MarshalJSON()
+	if m.async != nil {
+		m.async.deliver(handler, request)
+		return nil
+	}
+
+	cctx, err := newContext(request)
 	if err != nil {
 		return err
 	}
 
-	readCloser := io.NopCloser(bytes.NewReader(data))
-
-	cctx.SetBody(readCloser)
-
 	return handler(cctx)
 }
 
 //nolint:wrapcheck
 func (m MockProvider) SendRaw(request request.Request) error {
-	cctx := comcontext.NewDefaultContext()
-
 	handler, ok := m.handlers[request.GetPath()]
 	if !ok {
 		return errNotFound
 	}
 
-	data, err := request.GetData().MarshalJSON()
+	cctx, err := newContext(request)
 	if err != nil {
 		return err
 	}
 
-	readCloser := io.NopCloser(bytes.NewReader(data))
-
-	cctx.SetBody(readCloser)
-
 	return handler(cctx)
 }
 
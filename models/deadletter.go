@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// Attempt это структура данных о неудачной попытке обработки задачи.
+type Attempt struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Error      string    `json:"error"`
+}
+
+// DeadLetterReason это причина, по которой задача попала в dead letter.
+type DeadLetterReason string
+
+const (
+	// DeadLetterMaxAttempts означает, что исчерпаны попытки обработки задачи.
+	DeadLetterMaxAttempts DeadLetterReason = "max_attempts"
+	// DeadLetterPermanent означает, что обработчик вернул ошибку, при которой повтор не имеет смысла.
+	DeadLetterPermanent DeadLetterReason = "permanent"
+	// DeadLetterRetryFailed означает, что задачу не удалось поставить на повтор.
+	DeadLetterRetryFailed DeadLetterReason = "retry_failed"
+)
+
+// DeadLetter это структура данных о задаче, которая больше не будет обработана.
+type DeadLetter struct {
+	FirstAttemptAt time.Time        `json:"first_attempt_at"`
+	DeadAt         time.Time        `json:"dead_at"`
+	Task           Task             `json:"task"`
+	Error          string           `json:"error"`
+	Reason         DeadLetterReason `json:"reason"`
+	Attempts       []Attempt        `json:"attempts"`
+}
+
+// DeadLetterFilter describes which dead letters should be returned. Empty fields are ignored.
+type DeadLetterFilter struct {
+	Name string
+	// Limit limits amount of returned dead letters, 0 means unlimited.
+	Limit int
+}
+
+// Match reports whether dead letter satisfies filter.
+func (f DeadLetterFilter) Match(letter DeadLetter) bool {
+	return f.Name == "" || f.Name == letter.Task.Name
+}
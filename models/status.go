@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+// TaskState это состояние задачи.
+type TaskState string
+
+const (
+	// TaskStatePending означает, что задача создана и ожидает обработки.
+	TaskStatePending TaskState = "pending"
+	// TaskStateRunning означает, что задача обрабатывается.
+	TaskStateRunning TaskState = "running"
+	// TaskStateRetrying означает, что обработка завершилась ошибкой и задача ожидает повтора.
+	TaskStateRetrying TaskState = "retrying"
+	// TaskStateSucceeded означает, что задача успешно обработана.
+	TaskStateSucceeded TaskState = "succeeded"
+	// TaskStateDead означает, что задача больше не будет обработана.
+	TaskStateDead TaskState = "dead"
+	// TaskStateCancelled означает, что задача была отменена.
+	TaskStateCancelled TaskState = "cancelled"
+)
+
+// TaskStatus это структура данных о состоянии задачи.
+type TaskStatus struct {
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	StartedAt  time.Time `json:"started_at,omitzero"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+	NextRunAt  time.Time `json:"next_run_at,omitzero"`
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	State      TaskState `json:"state"`
+	LastError  string    `json:"last_error,omitempty"`
+	Attempts   int       `json:"attempts"`
+}
+
+// StatusFilter describes which statuses should be returned by List. Empty fields are ignored.
+type StatusFilter struct {
+	Name   string
+	States []TaskState
+	// Limit limits amount of returned statuses, 0 means unlimited.
+	Limit int
+}
+
+// Match reports whether status satisfies filter.
+func (f StatusFilter) Match(status TaskStatus) bool {
+	if f.Name != "" && f.Name != status.Name {
+		return false
+	}
+
+	if len(f.States) == 0 {
+		return true
+	}
+
+	for _, state := range f.States {
+		if state == status.State {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,15 +1,102 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// TaskVersion is a version of the task message format. Messages without version have legacy
+// format with metadata stored in Params.
+const TaskVersion = 2
+
+// Origin describes why the task message was published.
+type Origin string
+
+const (
+	// OriginCreate means task was created by Create.
+	OriginCreate Origin = "create"
+	// OriginScheduled means task is an occurrence of the schedule.
+	OriginScheduled Origin = "scheduled"
+	// OriginDelayed means task was created by CreateDelayed.
+	OriginDelayed Origin = "delayed"
+	// OriginRetry means task is a retry of failed attempt.
+	OriginRetry Origin = "retry"
+)
+
+// Meta это метаданные задачи, которые передаются вместе с ней.
+type Meta struct {
+	FirstEnqueuedAt time.Time `json:"first_enqueued_at,omitzero"`
+	Origin          Origin    `json:"origin,omitempty"`
+	LastError       string    `json:"last_error,omitempty"`
+	// TraceParent is a W3C trace context of the code which created the task.
+	TraceParent string `json:"traceparent,omitempty"`
+	// Attempt is a number of the current attempt, it starts from 1.
+	Attempt int `json:"attempt"`
+}
 
 // Task это структура данных о задаче.
 type Task struct {
-	StartTime      time.Time         `json:"start_time"`
-	TimeOfNextExec time.Time         `json:"-"`
-	Params         map[string]string `json:"params"`
-	Name           string            `json:"name"`
-	Host           string            `json:"host,omitempty"`
-	Period         time.Duration     `json:"-"`
+	Meta           Meta      `json:"meta"`
+	ID             string    `json:"id,omitempty"`
+	StartTime      time.Time `json:"start_time"`
+	TimeOfNextExec time.Time `json:"-"`
+	FireTime       time.Time `json:"fire_time,omitzero"`
+	// EnqueuedAt is a time when task was published to delay topic.
+	EnqueuedAt time.Time         `json:"enqueued_at,omitzero"`
+	Params     map[string]string `json:"params"`
+	Payload    json.RawMessage   `json:"payload,omitempty"`
+	Name       string            `json:"name"`
+	Key        string            `json:"key,omitempty"`
+	Host       string            `json:"host,omitempty"`
+	Period     time.Duration     `json:"-"`
+	// RetryDelay is a delay before the current retry, it's used by decorrelated jitter.
+	RetryDelay time.Duration `json:"retry_delay,omitempty"`
+	// History contains failed attempts of the task: the first one and the last ones.
+	History []Attempt `json:"history,omitempty"`
+	// Tombstone marks message which cancels previously created task with the same ID.
+	Tombstone bool `json:"tombstone,omitempty"`
+	Version   int  `json:"version,omitempty"`
+}
+
+// Upgrade converts task of the legacy format to the current one: metadata is moved from Params to Meta.
+func (t *Task) Upgrade() {
+	if t.Version >= TaskVersion {
+		return
+	}
+
+	t.Version = TaskVersion
+
+	retries, _ := strconv.Atoi(t.Params["attempts"])
+	t.Meta.Attempt = retries + 1
+
+	switch {
+	case retries > 0:
+		t.Meta.Origin = OriginRetry
+	case t.Params["scheduled"] == "true":
+		t.Meta.Origin = OriginScheduled
+	case t.Params["delayed"] == "true":
+		t.Meta.Origin = OriginDelayed
+	default:
+		t.Meta.Origin = OriginCreate
+	}
+
+	if len(t.Params) == 0 {
+		return
+	}
+
+	// Params could be shared with another task, so they are copied.
+	params := make(map[string]string, len(t.Params))
+
+	for key, value := range t.Params {
+		switch key {
+		case "attempts", "scheduled", "delayed":
+		default:
+			params[key] = value
+		}
+	}
+
+	t.Params = params
 }
 
 type RetryPolicy struct {
@@ -21,4 +108,67 @@ type RetryPolicy struct {
 	MaximumInterval time.Duration
 	// Specifies the maximum number of execution attempts. 0  means unlimited.
 	MaximumAttempts int
+	// How the interval grows with attempts. default value is BackoffExponential
+	Strategy BackoffStrategy
+	// Randomization of the interval which spreads retries of simultaneously failed tasks.
+	// default value is JitterNone
+	Jitter Jitter
+	// Custom schedule of retries, when set it's used instead of Strategy and Jitter. Its result is
+	// limited to [0, MaximumInterval].
+	BackoffFunc BackoffFunc
+}
+
+// BackoffFunc returns delay before retry of the attempt which failed with err, attempts start from 1.
+type BackoffFunc func(attempt int, err error) time.Duration
+
+// BackoffStrategy defines how retry interval grows.
+type BackoffStrategy string
+
+const (
+	// BackoffExponential multiplies InitialInterval by BackoffCoefficient on every attempt.
+	BackoffExponential BackoffStrategy = "exponential"
+	// BackoffLinear increases interval by InitialInterval on every attempt.
+	BackoffLinear BackoffStrategy = "linear"
+	// BackoffFixed always waits InitialInterval.
+	BackoffFixed BackoffStrategy = "fixed"
+)
+
+// Jitter defines randomization of retry interval.
+type Jitter string
+
+const (
+	// JitterNone uses interval as is.
+	JitterNone Jitter = "none"
+	// JitterFull takes random interval between 0 and computed interval.
+	JitterFull Jitter = "full"
+	// JitterEqual takes half of computed interval plus random part of the other half.
+	JitterEqual Jitter = "equal"
+	// JitterDecorrelated takes random interval between InitialInterval and triple previous interval.
+	// Strategy is ignored in this case.
+	JitterDecorrelated Jitter = "decorrelated"
+)
+
+// MisfirePolicy defines what happens with occurrences of the schedule which were missed
+// by more than misfire threshold (e.g. while the process was paused).
+type MisfirePolicy string
+
+const (
+	// MisfireFireOnce creates single task for all missed occurrences and realigns schedule.
+	MisfireFireOnce MisfirePolicy = "fire_once"
+	// MisfireFireAll creates task for every missed occurrence.
+	MisfireFireAll MisfirePolicy = "fire_all"
+	// MisfireSkip skips missed occurrences.
+	MisfireSkip MisfirePolicy = "skip"
+)
+
+// ScheduledTask это структура данных о зарегистрированном расписании задачи.
+type ScheduledTask struct {
+	NextRunAt time.Time
+	Params    map[string]string
+	ID        string
+	Name      string
+	// Spec is cron expression of the schedule, fixed period schedules are described as "@every <period>".
+	Spec          string
+	MisfirePolicy MisfirePolicy
+	Paused        bool
 }
@@ -2,20 +2,40 @@ package tasks
 
 import (
 	"context"
+	"time"
 
 	"github.com/mc2soft/framework/communication"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/broker"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/deadletter"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/lease"
 	"gitlab.local.iti.domain/mc2/golibs/tasks/logger"
 	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/status"
 )
 
 type options struct {
-	ctx         context.Context
-	logger      logger.Logger
-	provider    communication.Provider
-	topic       string
-	numWorkers  int
-	queueSize   int
-	retryPolicy models.RetryPolicy
+	ctx              context.Context
+	logger           logger.Logger
+	provider         communication.Provider
+	broker           broker.Broker
+	topic            string
+	numWorkers       int
+	queueSize        int
+	retryPolicy      models.RetryPolicy
+	executionTimeout time.Duration
+	statusStore      status.Store
+	leaseBackend     lease.Backend
+	leaseTTL         time.Duration
+	delayTiers       []time.Duration
+	retryTiers       []time.Duration
+	deadLetterTopic  string
+	deadLetterStore  deadletter.Store
+	queues           map[string]QueueConfig
+	maxInFlight      int
+	// ackAfterProcessing defers ack of the task message until task is processed.
+	ackAfterProcessing bool
+	// skipUnknownTasks means topic is shared with other services, tasks without handler are expected.
+	skipUnknownTasks bool
 }
 
 // Option is an interface for configuration options.
@@ -59,10 +79,55 @@ func (po *providerOption) apply(o *options) {
 	o.topic = po.topic
 }
 
+// WithProvider sets provider of the framework which is used as a broker.
 func WithProvider(provider communication.Provider, topic string) Option {
 	return &providerOption{provider: provider, topic: topic}
 }
 
+type ackAfterProcessingOption struct{}
+
+func (ackAfterProcessingOption) apply(o *options) {
+	o.ackAfterProcessing = true
+}
+
+// WithAckAfterProcessing acknowledges task message only after handler succeeds, the task is handed to
+// retry or dead-lettered, so tasks which are in progress on crash are delivered again. It should be
+// used with broker which acknowledges messages asynchronously, e.g. broker.NewKafka(...). It requires
+// WithDurableDelays and WithDurableRetries, so retried and postponed tasks are not kept in memory.
+// Broker which redelivers unacked messages must wait for ack longer than the task waits for a worker and
+// runs, see broker.WithAckTimeout; shorter ack timeout is logged as error.
+func WithAckAfterProcessing() Option {
+	return ackAfterProcessingOption{}
+}
+
+type skipUnknownTasksOption struct{}
+
+func (skipUnknownTasksOption) apply(o *options) {
+	o.skipUnknownTasks = true
+}
+
+// WithSkipUnknownTasks is used when topic is shared by services with different handlers, so tasks
+// without handler in this instance are skipped silently. Otherwise such tasks are logged as errors.
+func WithSkipUnknownTasks() Option {
+	return skipUnknownTasksOption{}
+}
+
+type brokerOption struct {
+	broker broker.Broker
+	topic  string
+}
+
+func (bo *brokerOption) apply(o *options) {
+	o.broker = bo.broker
+	o.topic = bo.topic
+}
+
+// WithBroker sets broker which is used instead of provider, e.g. broker.NewMemory() for tests.
+// Broker is closed by Stop.
+func WithBroker(b broker.Broker, topic string) Option {
+	return &brokerOption{broker: b, topic: topic}
+}
+
 type WorkerOption struct {
 	numWorkers int
 }
@@ -98,3 +163,126 @@ func (ro *retryPolicyOption) apply(o *options) {
 func WithRetryPolicy(retryPolicy models.RetryPolicy) Option {
 	return &retryPolicyOption{retryPolicy: retryPolicy}
 }
+
+type executionTimeoutOption struct {
+	timeout time.Duration
+}
+
+func (eo *executionTimeoutOption) apply(o *options) {
+	o.executionTimeout = eo.timeout
+}
+
+// WithExecutionTimeout sets deadline for a single handler execution. Default is 10 minutes.
+func WithExecutionTimeout(timeout time.Duration) Option {
+	return &executionTimeoutOption{timeout: timeout}
+}
+
+type statusStoreOption struct {
+	store status.Store
+}
+
+func (so *statusStoreOption) apply(o *options) {
+	o.statusStore = so.store
+}
+
+// WithStatusStore enables task status tracking in passed store. See status.NewMemoryStore
+// and status.NewFileStore.
+func WithStatusStore(store status.Store) Option {
+	return &statusStoreOption{store: store}
+}
+
+type leaseOption struct {
+	backend lease.Backend
+	ttl     time.Duration
+}
+
+func (lo *leaseOption) apply(o *options) {
+	o.leaseBackend = lo.backend
+	o.leaseTTL = lo.ttl
+}
+
+// WithLease makes scheduled tasks fire on single instance of the cluster: only the owner of
+// the lease creates scheduled tasks. Lease fails over to another instance if owner doesn't
+// renew it within ttl (default is 15 seconds).
+func WithLease(backend lease.Backend, ttl time.Duration) Option {
+	return &leaseOption{backend: backend, ttl: ttl}
+}
+
+type durableDelaysOption struct {
+	tiers []time.Duration
+}
+
+func (do *durableDelaysOption) apply(o *options) {
+	o.delayTiers = do.tiers
+	if len(o.delayTiers) == 0 {
+		o.delayTiers = defaultDelayTiers
+	}
+}
+
+// WithDurableDelays keeps delayed tasks in delay topics "<topic>.delay.<tier>" instead of memory,
+// so they survive restarts. Task waits in topic of the largest tier which is not longer than time
+// left till start. Default tiers are 1s, 5s, 30s, 1m, 5m, 30m and 1h.
+func WithDurableDelays(tiers ...time.Duration) Option {
+	return &durableDelaysOption{tiers: tiers}
+}
+
+type durableRetriesOption struct {
+	tiers []time.Duration
+}
+
+func (ro *durableRetriesOption) apply(o *options) {
+	o.retryTiers = ro.tiers
+	if len(o.retryTiers) == 0 {
+		o.retryTiers = defaultRetryTiers
+	}
+}
+
+// WithDurableRetries keeps retries waiting for backoff in retry topics "<topic>.retry.<tier>" instead
+// of memory, so backoff of the retry policy is kept across restarts. Default tiers are 1s, 5s, 30s,
+// 1m and 5m.
+func WithDurableRetries(tiers ...time.Duration) Option {
+	return &durableRetriesOption{tiers: tiers}
+}
+
+type deadLetterTopicOption struct {
+	topic string
+}
+
+func (do *deadLetterTopicOption) apply(o *options) {
+	o.deadLetterTopic = do.topic
+}
+
+// WithDeadLetterTopic publishes tasks which won't be retried anymore to the topic as models.DeadLetter.
+// Dead letters are also kept in dead letter store (in memory by default, see WithDeadLetterStore).
+func WithDeadLetterTopic(topic string) Option {
+	return &deadLetterTopicOption{topic: topic}
+}
+
+type deadLetterStoreOption struct {
+	store deadletter.Store
+}
+
+func (do *deadLetterStoreOption) apply(o *options) {
+	o.deadLetterStore = do.store
+}
+
+// WithDeadLetterStore sets storage of dead letters which are available by ListDeadLetters,
+// GetDeadLetter and Requeue.
+func WithDeadLetterStore(store deadletter.Store) Option {
+	return &deadLetterStoreOption{store: store}
+}
+
+type maxInFlightOption struct {
+	maxInFlight int
+}
+
+func (mo *maxInFlightOption) apply(o *options) {
+	o.maxInFlight = mo.maxInFlight
+}
+
+// WithMaxInFlight limits amount of tasks created by CreateAsync which are not delivered yet, CreateAsync
+// waits for delivery of previous tasks when limit is reached. Default limit is 1000, it's used if
+// maxInFlight isn't positive.
+func WithMaxInFlight(maxInFlight int) Option {
+	return &maxInFlightOption{maxInFlight: maxInFlight}
+}
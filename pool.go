@@ -0,0 +1,242 @@
+package tasks
+
+import (
+	"hash/fnv"
+	"time"
+
+	"gitlab.local.iti.domain/mc2/golibs/tasks/logger"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+)
+
+// keyBlockMargin is a time after StartTime of the postponed task when its key is unblocked if the task
+// didn't come back.
+const keyBlockMargin = time.Minute
+
+// workerPool is a queue of tasks processed by workers. Tasks with key are dispatched to the shard of
+// the key, so tasks sharing a key are processed by one worker in order.
+type workerPool struct {
+	queue  chan queuedTask
+	shards []chan queuedTask
+}
+
+// keyState keeps order of tasks sharing a key while one of them waits for retry.
+type keyState struct {
+	// blockedBy is ID of the task waiting for retry, the next tasks of the key are parked until it's done.
+	blockedBy string
+	parked    []queuedTask
+	// deadline is a time when key is unblocked if the task didn't come back.
+	deadline time.Time
+	// generation is changed on every block, so worker knows whether task was postponed again.
+	generation int
+}
+
+func newWorkerPool(workers, queueSize int) *workerPool {
+	p := &workerPool{
+		queue:  make(chan queuedTask, queueSize),
+		shards: make([]chan queuedTask, workers),
+	}
+
+	for i := range p.shards {
+		p.shards[i] = make(chan queuedTask, queueSize)
+	}
+
+	return p
+}
+
+// dispatch returns queue for the task: shard of the key or common queue of the pool.
+func (p *workerPool) dispatch(task models.Task) chan queuedTask {
+	if task.Key == "" {
+		return p.queue
+	}
+
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(task.Key))
+
+	return p.shards[hash.Sum32()%uint32(len(p.shards))]
+}
+
+func (p *workerPool) close() {
+	close(p.queue)
+
+	for _, shard := range p.shards {
+		close(shard)
+	}
+}
+
+// len returns amount of tasks waiting for workers.
+func (p *workerPool) len() int {
+	queued := len(p.queue)
+
+	for _, shard := range p.shards {
+		queued += len(shard)
+	}
+
+	return queued
+}
+
+// blockKey parks the next tasks of the task key until the task is processed again. It's called
+// when keyed task is postponed for retry, so tasks sharing a key keep order. Postponed task could be
+// consumed by another instance, e.g. after rebalance, so key is blocked till StartTime of the task plus
+// keyBlockMargin at most. Key isn't blocked if broker doesn't deliver tasks sharing a key in order.
+func (t *Tasks) blockKey(task models.Task) {
+	// Parked tasks are released on Stop, so keys are not blocked anymore.
+	if task.Key == "" || !t.keyOrdered || !t.AreConsumersActive.Load() {
+		return
+	}
+
+	t.keysMutex.Lock()
+	defer t.keysMutex.Unlock()
+
+	state, ok := t.keys[task.Key]
+	if !ok {
+		state = &keyState{}
+		t.keys[task.Key] = state
+	}
+
+	state.blockedBy = task.ID
+	state.deadline = task.StartTime.Add(keyBlockMargin)
+	state.generation++
+
+	generation := state.generation
+
+	time.AfterFunc(time.Until(state.deadline), func() {
+		t.expireKey(task.Key, generation)
+	})
+}
+
+// expireKey unblocks the key if it's still blocked by the same task and resumes its parked tasks.
+func (t *Tasks) expireKey(key string, generation int) {
+	t.keysMutex.Lock()
+
+	state, ok := t.keys[key]
+	if !ok || state.generation != generation || state.blockedBy == "" {
+		t.keysMutex.Unlock()
+		return
+	}
+
+	t.opts.logger.Logf(logger.LogLevelError, "key is unblocked, postponed task didn't come back: %s",
+		map[string]interface{}{"key": key, "task_id": state.blockedBy, "parked": len(state.parked)}, key)
+
+	state.blockedBy = ""
+	wake := t.wakeKey(key, state)
+
+	t.keysMutex.Unlock()
+
+	if wake != nil && !t.enqueue(*wake) {
+		t.opts.logger.Log(logger.LogLevelError, "resume parked tasks error: tasks are stopped",
+			map[string]interface{}{"key": key})
+	}
+}
+
+// releaseKeys unblocks all keys and resumes their parked tasks, so they are processed before shutdown.
+func (t *Tasks) releaseKeys() {
+	t.keysMutex.Lock()
+
+	wakes := make([]queuedTask, 0)
+
+	for key, state := range t.keys {
+		state.blockedBy = ""
+
+		if wake := t.wakeKey(key, state); wake != nil {
+			wakes = append(wakes, *wake)
+		}
+	}
+
+	t.keysMutex.Unlock()
+
+	for _, wake := range wakes {
+		t.enqueue(wake)
+	}
+}
+
+// wakeKey returns entry which resumes parked tasks of the key, it's nil if there are no parked tasks.
+// Entry is dispatched as the first parked task, so it's processed by the worker of the task.
+func (t *Tasks) wakeKey(key string, state *keyState) *queuedTask {
+	if len(state.parked) == 0 {
+		delete(t.keys, key)
+		return nil
+	}
+
+	return &queuedTask{task: state.parked[0].task, wake: true}
+}
+
+// parkedTasks returns amount of tasks parked by blocked keys.
+func (t *Tasks) parkedTasks() int {
+	t.keysMutex.Lock()
+	defer t.keysMutex.Unlock()
+
+	parked := 0
+
+	for _, state := range t.keys {
+		parked += len(state.parked)
+	}
+
+	return parked
+}
+
+// acquireKey returns task which should be processed now: the task itself or the first parked task of
+// the key. ok is false if the task is parked because key is blocked by another task.
+func (t *Tasks) acquireKey(queued queuedTask) (queuedTask, int, bool) {
+	t.keysMutex.Lock()
+	defer t.keysMutex.Unlock()
+
+	state, ok := t.keys[queued.task.Key]
+	if !ok {
+		return queued, 0, !queued.wake
+	}
+
+	// Cancelled task doesn't come back, expired one could be consumed by another instance.
+	if state.blockedBy != "" && (t.isCancelled(state.blockedBy) || time.Now().After(state.deadline)) {
+		state.blockedBy = ""
+	}
+
+	if state.blockedBy != "" && state.blockedBy != queued.task.ID {
+		if !queued.wake {
+			state.parked = append(state.parked, queued)
+		}
+
+		return queuedTask{}, 0, false
+	}
+
+	if state.blockedBy == "" && len(state.parked) > 0 {
+		if !queued.wake {
+			state.parked = append(state.parked, queued)
+		}
+
+		queued, state.parked = state.parked[0], state.parked[1:]
+	} else if queued.wake {
+		return queuedTask{}, 0, false
+	}
+
+	return queued, state.generation, true
+}
+
+// releaseKey unblocks the key if processed task wasn't postponed again and returns the next parked
+// task of the key.
+func (t *Tasks) releaseKey(task models.Task, generation int) (queuedTask, int, bool) {
+	t.keysMutex.Lock()
+	defer t.keysMutex.Unlock()
+
+	state, ok := t.keys[task.Key]
+	if !ok {
+		return queuedTask{}, 0, false
+	}
+
+	if state.blockedBy == task.ID && state.generation == generation {
+		state.blockedBy = ""
+	}
+
+	if state.blockedBy != "" {
+		return queuedTask{}, 0, false
+	}
+
+	if len(state.parked) == 0 {
+		delete(t.keys, task.Key)
+		return queuedTask{}, 0, false
+	}
+
+	next := state.parked[0]
+	state.parked = state.parked[1:]
+
+	return next, state.generation, true
+}
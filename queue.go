@@ -0,0 +1,152 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+
+	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+)
+
+// QueueConfig is a configuration of the named queue, empty fields get values of the instance.
+type QueueConfig struct {
+	// RetryPolicy is a retry policy of handlers bound to the queue without own retry policy.
+	RetryPolicy *models.RetryPolicy
+	// Topic is a topic of the queue, it must differ from the main topic and topics of other queues.
+	Topic string
+	// Tasks are names of tasks routed to the queue when their handlers are not registered in this
+	// instance, e.g. in service which only creates tasks.
+	Tasks []string
+	// Workers is an amount of workers of the queue.
+	Workers int
+	// QueueSize is a size of in-memory queue of tasks waiting for workers.
+	QueueSize int
+}
+
+// namedQueue is a queue with own topic and workers.
+type namedQueue struct {
+	pool        *workerPool
+	retryPolicy models.RetryPolicy
+	name        string
+	topic       string
+	workers     int
+}
+
+type queueOption struct {
+	cfg  QueueConfig
+	name string
+}
+
+func (qo *queueOption) apply(o *options) {
+	if o.queues == nil {
+		o.queues = make(map[string]QueueConfig)
+	}
+
+	o.queues[qo.name] = qo.cfg
+}
+
+// WithQueue adds named queue with own topic, workers, queue size and retry policy, so tasks of the queue
+// don't wait for workers busy with tasks of other queues. Handlers are bound to the queue by OnQueue.
+func WithQueue(name string, cfg QueueConfig) Option {
+	return &queueOption{cfg: cfg, name: name}
+}
+
+type onQueueOption struct {
+	queue string
+}
+
+func (oo *onQueueOption) apply(o *handlerOptions) {
+	o.queue = oo.queue
+}
+
+// OnQueue binds handler to the named queue: tasks are published to the topic of the queue and processed
+// by its workers.
+func OnQueue(name string) HandlerOption {
+	return &onQueueOption{queue: name}
+}
+
+// initQueues creates named queues and routes of their tasks.
+func (t *Tasks) initQueues() error {
+	t.queues = make(map[string]*namedQueue, len(t.opts.queues))
+	t.routes = make(map[string]*namedQueue)
+
+	topics := map[string]bool{t.opts.topic: true}
+
+	for name, cfg := range t.opts.queues {
+		if name == "" || cfg.Topic == "" || topics[cfg.Topic] {
+			return fmt.Errorf("%w: name=%s, topic=%s", ErrInvalidQueue, name, cfg.Topic)
+		}
+
+		topics[cfg.Topic] = true
+
+		q := &namedQueue{
+			retryPolicy: t.opts.retryPolicy,
+			name:        name,
+			topic:       cfg.Topic,
+			workers:     cfg.Workers,
+		}
+
+		if cfg.RetryPolicy != nil {
+			q.retryPolicy = retryPolicyWithDefaults(*cfg.RetryPolicy)
+		}
+
+		if q.workers <= 0 {
+			q.workers = t.opts.numWorkers
+		}
+
+		queueSize := cfg.QueueSize
+		if queueSize <= 0 {
+			queueSize = t.opts.queueSize
+		}
+
+		q.pool = newWorkerPool(q.workers, queueSize)
+		t.queues[name] = q
+
+		for _, taskName := range cfg.Tasks {
+			t.routes[taskName] = q
+		}
+	}
+
+	return nil
+}
+
+// topicFor returns topic of the queue of the task or the main topic.
+func (t *Tasks) topicFor(taskName string) string {
+	t.tasksHandlersMutex.RLock()
+	defer t.tasksHandlersMutex.RUnlock()
+
+	q, ok := t.routes[taskName]
+	if ok {
+		return q.topic
+	}
+
+	return t.opts.topic
+}
+
+// subscribeQueues subscribes to topics of the named queues.
+func (t *Tasks) subscribeQueues() error {
+	for _, q := range t.queues {
+		err := t.subscribe(q.topic, t.handleTask)
+		if err != nil {
+			return fmt.Errorf("subscribe queue %s: %w", q.name, err)
+		}
+	}
+
+	return nil
+}
+
+// startQueueWorkers starts workers of the named queues.
+func (t *Tasks) startQueueWorkers(ctx context.Context) {
+	for _, q := range t.queues {
+		for i := 0; i < q.workers; i++ {
+			t.wg.Add(1)
+			go t.taskWorker(ctx, i+1, q.pool, i)
+		}
+	}
+}
+
+// closeQueues closes queues of the named queues, so their workers exit.
+func (t *Tasks) closeQueues() {
+	for _, q := range t.queues {
+		q.pool.close()
+	}
+}
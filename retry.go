@@ -1,6 +1,7 @@
 package tasks
 
 import (
+	"container/heap"
 	"time"
 
 	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
@@ -34,3 +35,15 @@ func (rq *RetryQueue) Pop() any {
 
 	return task
 }
+
+// Remove removes task with passed ID from the queue and reports whether it was found.
+func (rq *RetryQueue) Remove(id string) bool {
+	for i, task := range *rq {
+		if task.Task.ID == id {
+			heap.Remove(rq, i)
+			return true
+		}
+	}
+
+	return false
+}
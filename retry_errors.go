@@ -0,0 +1,58 @@
+package tasks
+
+import (
+	"errors"
+	"time"
+)
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps error of the handler which won't go away on retry, e.g. validation error.
+// Task failed with such error is dead-lettered without retries.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err or any error it wraps was created by Permanent.
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+
+	return errors.As(err, &permanent)
+}
+
+type retryAfterError struct {
+	err   error
+	delay time.Duration
+}
+
+func (e *retryAfterError) Error() string { return e.err.Error() }
+func (e *retryAfterError) Unwrap() error { return e.err }
+
+// RetryAfter wraps error of the handler to retry the task after delay instead of backoff of the
+// retry policy, e.g. when external API responded with Retry-After. MaximumAttempts is still applied.
+func RetryAfter(err error, delay time.Duration) error {
+	if err == nil {
+		return nil
+	}
+
+	return &retryAfterError{err: err, delay: delay}
+}
+
+// retryDelay returns delay requested by RetryAfter.
+func retryDelay(err error) (time.Duration, bool) {
+	var retryAfter *retryAfterError
+	if !errors.As(err, &retryAfter) {
+		return 0, false
+	}
+
+	return retryAfter.delay, true
+}
@@ -0,0 +1,404 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"gitlab.local.iti.domain/mc2/golibs/tasks/cron"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/logger"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+)
+
+// scheduledTask is a registered schedule. task.TimeOfNextExec holds next occurrence.
+type scheduledTask struct {
+	schedule cron.Schedule
+	spec     string
+	task     models.Task
+	opts     scheduleOptions
+	paused   bool
+}
+
+const (
+	defaultMisfireThreshold = time.Minute
+	// maxMisfireCatchUp limits number of tasks created at once by MisfireFireAll policy.
+	maxMisfireCatchUp = 1000
+)
+
+type scheduleOptions struct {
+	location         *time.Location
+	misfirePolicy    models.MisfirePolicy
+	misfireThreshold time.Duration
+}
+
+func (so *scheduleOptions) validate() error {
+	switch so.misfirePolicy {
+	case models.MisfireFireOnce, models.MisfireFireAll, models.MisfireSkip:
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownMisfirePolicy, so.misfirePolicy)
+	}
+
+	return nil
+}
+
+// ScheduleOption is an interface for scheduled task options.
+type ScheduleOption interface {
+	apply(o *scheduleOptions)
+}
+
+func newScheduleOptions(opts []ScheduleOption) *scheduleOptions {
+	so := &scheduleOptions{
+		location:         time.UTC,
+		misfirePolicy:    models.MisfireFireOnce,
+		misfireThreshold: defaultMisfireThreshold,
+	}
+
+	for _, opt := range opts {
+		opt.apply(so)
+	}
+
+	return so
+}
+
+type timezoneOption struct {
+	location *time.Location
+}
+
+func (to *timezoneOption) apply(o *scheduleOptions) {
+	o.location = to.location
+}
+
+// WithTimezone sets timezone in which cron expression is evaluated. Default is UTC.
+// CRON_TZ= prefix of the expression takes precedence.
+func WithTimezone(location *time.Location) ScheduleOption {
+	return &timezoneOption{location: location}
+}
+
+type misfirePolicyOption struct {
+	policy models.MisfirePolicy
+}
+
+func (mo *misfirePolicyOption) apply(o *scheduleOptions) {
+	o.misfirePolicy = mo.policy
+}
+
+// WithMisfirePolicy sets what to do with occurrences missed by more than misfire threshold,
+// e.g. after long GC pause or when the process was down. Default is models.MisfireFireOnce.
+func WithMisfirePolicy(policy models.MisfirePolicy) ScheduleOption {
+	return &misfirePolicyOption{policy: policy}
+}
+
+type misfireThresholdOption struct {
+	threshold time.Duration
+}
+
+func (mo *misfireThresholdOption) apply(o *scheduleOptions) {
+	o.misfireThreshold = mo.threshold
+}
+
+// WithMisfireThreshold sets how late occurrence could be created before it's considered misfired.
+// Default is 1 minute.
+func WithMisfireThreshold(threshold time.Duration) ScheduleOption {
+	return &misfireThresholdOption{threshold: threshold}
+}
+
+// CreateCron creates task scheduled by cron expression and returns ID of the schedule.
+// Standard 5 and 6 (with seconds) fields syntax and descriptors like @daily are supported,
+// see cron package for details.
+func (t *Tasks) CreateCron(
+	_ context.Context,
+	taskName string,
+	params map[string]string,
+	spec string,
+	opts ...ScheduleOption,
+) (string, error) {
+	so := newScheduleOptions(opts)
+
+	schedule, err := cron.ParseInLocation(spec, so.location)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrCreateCron, err)
+	}
+
+	task := models.Task{
+		ID:             newTaskID(),
+		Name:           taskName,
+		Params:         params,
+		TimeOfNextExec: schedule.Next(time.Now().UTC()),
+	}
+
+	if task.TimeOfNextExec.IsZero() {
+		return "", fmt.Errorf("%w: %w: %q has no occurrences", ErrCreateCron, cron.ErrInvalidSpec, spec)
+	}
+
+	if task.Params == nil {
+		task.Params = map[string]string{}
+	}
+
+	err = t.addScheduled(task, schedule, spec, so)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrCreateCron, err)
+	}
+
+	return task.ID, nil
+}
+
+// addScheduled registers schedule of the task, only one schedule per task name is allowed.
+func (t *Tasks) addScheduled(task models.Task, schedule cron.Schedule, spec string, so *scheduleOptions) error {
+	err := so.validate()
+	if err != nil {
+		return err
+	}
+
+	t.scheduledTaskMutex.Lock()
+	defer t.scheduledTaskMutex.Unlock()
+
+	_, ok := t.scheduledTasks[task.Name]
+	if ok {
+		return fmt.Errorf("%w: %s", ErrTaskNameAlreadyRegistered, task.Name)
+	}
+
+	t.scheduledTasks[task.Name] = &scheduledTask{schedule: schedule, spec: spec, task: task, opts: *so}
+	t.notifyScheduleChanged()
+
+	return nil
+}
+
+// ListScheduled returns registered schedules ordered by task name.
+func (t *Tasks) ListScheduled(_ context.Context) []models.ScheduledTask {
+	t.scheduledTaskMutex.RLock()
+	defer t.scheduledTaskMutex.RUnlock()
+
+	result := make([]models.ScheduledTask, 0, len(t.scheduledTasks))
+
+	for _, entry := range t.scheduledTasks {
+		params := make(map[string]string, len(entry.task.Params))
+		for key, value := range entry.task.Params {
+			params[key] = value
+		}
+
+		result = append(result, models.ScheduledTask{
+			NextRunAt:     entry.task.TimeOfNextExec,
+			Params:        params,
+			ID:            entry.task.ID,
+			Name:          entry.task.Name,
+			Spec:          entry.spec,
+			MisfirePolicy: entry.opts.misfirePolicy,
+			Paused:        entry.paused,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	return result
+}
+
+// PauseScheduled stops creating tasks by the schedule until ResumeScheduled is called.
+func (t *Tasks) PauseScheduled(_ context.Context, taskName string) error {
+	t.scheduledTaskMutex.Lock()
+	defer t.scheduledTaskMutex.Unlock()
+
+	entry, ok := t.scheduledTasks[taskName]
+	if !ok {
+		return fmt.Errorf("%w: %w: %s", ErrPauseScheduled, ErrScheduledTaskNotFound, taskName)
+	}
+
+	entry.paused = true
+	t.notifyScheduleChanged()
+
+	return nil
+}
+
+// ResumeScheduled resumes paused schedule. Occurrences missed during the pause are skipped.
+func (t *Tasks) ResumeScheduled(_ context.Context, taskName string) error {
+	t.scheduledTaskMutex.Lock()
+	defer t.scheduledTaskMutex.Unlock()
+
+	entry, ok := t.scheduledTasks[taskName]
+	if !ok {
+		return fmt.Errorf("%w: %w: %s", ErrResumeScheduled, ErrScheduledTaskNotFound, taskName)
+	}
+
+	if !entry.paused {
+		return nil
+	}
+
+	entry.paused = false
+	entry.task.TimeOfNextExec = nextAfter(entry.schedule, entry.task.TimeOfNextExec, time.Now().UTC())
+	t.notifyScheduleChanged()
+
+	return nil
+}
+
+// RemoveScheduled removes schedule, tasks which were already created are not affected.
+func (t *Tasks) RemoveScheduled(_ context.Context, taskName string) error {
+	t.scheduledTaskMutex.Lock()
+	defer t.scheduledTaskMutex.Unlock()
+
+	if _, ok := t.scheduledTasks[taskName]; !ok {
+		return fmt.Errorf("%w: %w: %s", ErrRemoveScheduled, ErrScheduledTaskNotFound, taskName)
+	}
+
+	delete(t.scheduledTasks, taskName)
+	t.notifyScheduleChanged()
+
+	return nil
+}
+
+// UpdateSchedule replaces schedule of the task with cron expression (use "@every 1h" for fixed
+// period). Next occurrence is calculated from now, ID and params of the schedule are kept.
+// Misfire options which are not passed keep their current values, timezone defaults to UTC.
+func (t *Tasks) UpdateSchedule(_ context.Context, taskName, spec string, opts ...ScheduleOption) error {
+	t.scheduledTaskMutex.Lock()
+	defer t.scheduledTaskMutex.Unlock()
+
+	entry, ok := t.scheduledTasks[taskName]
+	if !ok {
+		return fmt.Errorf("%w: %w: %s", ErrUpdateSchedule, ErrScheduledTaskNotFound, taskName)
+	}
+
+	so := entry.opts
+	so.location = time.UTC
+
+	for _, opt := range opts {
+		opt.apply(&so)
+	}
+
+	err := so.validate()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUpdateSchedule, err)
+	}
+
+	schedule, err := cron.ParseInLocation(spec, so.location)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUpdateSchedule, err)
+	}
+
+	next := schedule.Next(time.Now().UTC())
+	if next.IsZero() {
+		return fmt.Errorf("%w: %w: %q has no occurrences", ErrUpdateSchedule, cron.ErrInvalidSpec, spec)
+	}
+
+	entry.schedule = schedule
+	entry.spec = spec
+	entry.opts = so
+	entry.task.Period = 0
+	entry.task.TimeOfNextExec = next
+
+	if every, isEvery := schedule.(cron.Every); isEvery {
+		entry.task.Period = time.Duration(every)
+	}
+
+	t.notifyScheduleChanged()
+
+	return nil
+}
+
+// advance moves schedule past now and returns fire times of occurrences which should be created
+// according to misfire policy and number of misfired occurrences.
+func (st *scheduledTask) advance(now time.Time) ([]time.Time, int) {
+	var (
+		due    []time.Time
+		missed int
+	)
+
+	next := st.task.TimeOfNextExec
+	for !next.IsZero() && !next.After(now) && len(due) < maxMisfireCatchUp {
+		due = append(due, next)
+
+		if now.Sub(next) > st.opts.misfireThreshold {
+			missed++
+		}
+
+		next = st.schedule.Next(next)
+	}
+
+	if len(due) == 0 {
+		return nil, 0
+	}
+
+	if !next.IsZero() {
+		// Occurrences above catch up limit are dropped.
+		next = nextAfter(st.schedule, next, now)
+	}
+
+	st.task.TimeOfNextExec = next
+
+	if missed == 0 {
+		return due, 0
+	}
+
+	switch st.opts.misfirePolicy {
+	case models.MisfireFireAll:
+		return due, missed
+	case models.MisfireSkip:
+		return due[missed:], missed
+	default:
+		// Single task with the latest missed fire time represents all the missed occurrences, the
+		// occurrences within threshold are created as usual.
+		return due[missed-1:], missed
+	}
+}
+
+// nextAfter returns first occurrence of the schedule which is later than now, starting from next.
+func nextAfter(schedule cron.Schedule, next, now time.Time) time.Time {
+	if next.After(now) {
+		return next
+	}
+
+	// Keep fixed period schedules aligned to their start time.
+	if every, ok := schedule.(cron.Every); ok && every > 0 {
+		periods := now.Sub(next)/time.Duration(every) + 1
+
+		return next.Add(periods * time.Duration(every))
+	}
+
+	return schedule.Next(now)
+}
+
+// schedulerLeaseName returns name of the lease which owner creates scheduled tasks.
+func (t *Tasks) schedulerLeaseName() string {
+	return "tasks-scheduler:" + t.opts.topic
+}
+
+// acquireSchedulerLease acquires or renews scheduler lease and reports whether this instance
+// should create scheduled tasks.
+func (t *Tasks) acquireSchedulerLease(ctx context.Context) bool {
+	if t.opts.leaseBackend == nil {
+		return true
+	}
+
+	ok, err := t.opts.leaseBackend.Acquire(ctx, t.schedulerLeaseName(), t.leaseHolder, t.opts.leaseTTL)
+	if err != nil {
+		// Without lease another instance could create the same tasks, so skip this time.
+		t.opts.logger.Logf(logger.LogLevelError, "acquire scheduler lease error: %s",
+			map[string]interface{}{"holder": t.leaseHolder}, err.Error())
+
+		return false
+	}
+
+	return ok
+}
+
+func (t *Tasks) releaseSchedulerLease() {
+	if t.opts.leaseBackend == nil {
+		return
+	}
+
+	err := t.opts.leaseBackend.Release(t.opts.ctx, t.schedulerLeaseName(), t.leaseHolder)
+	if err != nil {
+		t.opts.logger.Logf(logger.LogLevelError, "release scheduler lease error: %s",
+			map[string]interface{}{"holder": t.leaseHolder}, err.Error())
+	}
+}
+
+// newLeaseHolder returns unique name of this instance.
+func newLeaseHolder() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return hostname + "-" + newTaskID()
+}
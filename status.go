@@ -0,0 +1,85 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gitlab.local.iti.domain/mc2/golibs/tasks/logger"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/status"
+)
+
+// Status returns status of the task by its ID.
+func (t *Tasks) Status(ctx context.Context, id string) (models.TaskStatus, error) {
+	if t.opts.statusStore == nil {
+		return models.TaskStatus{}, fmt.Errorf("%w: %w", ErrStatus, ErrStatusStoreNotSet)
+	}
+
+	taskStatus, err := t.opts.statusStore.Get(ctx, id)
+	if err != nil {
+		return models.TaskStatus{}, fmt.Errorf("%w: %w: task_id=%s", ErrStatus, err, id)
+	}
+
+	return taskStatus, nil
+}
+
+// List returns statuses of the tasks matching filter.
+func (t *Tasks) List(ctx context.Context, filter models.StatusFilter) ([]models.TaskStatus, error) {
+	if t.opts.statusStore == nil {
+		return nil, fmt.Errorf("%w: %w", ErrList, ErrStatusStoreNotSet)
+	}
+
+	statuses, err := t.opts.statusStore.List(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrList, err)
+	}
+
+	return statuses, nil
+}
+
+// updateStatus modifies task status in configured store. Store errors don't affect
+// task processing, so they are only logged.
+func (t *Tasks) updateStatus(ctx context.Context, task models.Task, update status.UpdateFunc) {
+	if t.opts.statusStore == nil || task.ID == "" {
+		return
+	}
+
+	now := time.Now().UTC()
+
+	err := t.opts.statusStore.Update(ctx, task.ID, func(taskStatus *models.TaskStatus) {
+		if taskStatus.CreatedAt.IsZero() {
+			taskStatus.CreatedAt = now
+		}
+
+		if task.Name != "" {
+			taskStatus.Name = task.Name
+		}
+		taskStatus.UpdatedAt = now
+
+		update(taskStatus)
+	})
+	if err != nil {
+		t.opts.logger.Logf(logger.LogLevelError, "update task status error: %s",
+			map[string]interface{}{"task_name": task.Name, "task_id": task.ID}, err.Error())
+	}
+}
+
+// markDead marks task which won't be processed anymore.
+func (t *Tasks) markDead(ctx context.Context, task models.Task, taskErr error) {
+	t.updateStatus(ctx, task, func(taskStatus *models.TaskStatus) {
+		taskStatus.State = models.TaskStateDead
+		taskStatus.LastError = taskErr.Error()
+		taskStatus.FinishedAt = time.Now().UTC()
+		taskStatus.NextRunAt = time.Time{}
+	})
+}
+
+// markRetrying marks task which waits for the next attempt till task.StartTime.
+func (t *Tasks) markRetrying(ctx context.Context, task models.Task, taskErr error) {
+	t.updateStatus(ctx, task, func(taskStatus *models.TaskStatus) {
+		taskStatus.State = models.TaskStateRetrying
+		taskStatus.LastError = taskErr.Error()
+		taskStatus.NextRunAt = task.StartTime
+	})
+}
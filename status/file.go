@@ -0,0 +1,242 @@
+package status
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+)
+
+const (
+	filePermissions = 0o600
+	// minCompactRecords is amount of records in the file which is never compacted.
+	minCompactRecords = 1000
+)
+
+// FileStore keeps task statuses in JSON lines file. Every update appends status to the file, file is
+// compacted when it has much more records than statuses or when statuses of finished tasks are evicted
+// after retention, see WithRetention. It suits single process access.
+type FileStore struct {
+	statuses map[string]models.TaskStatus
+	evictor  evictor
+	path     string
+	// records is amount of records in the file including outdated ones.
+	records int
+	// broken is set when append failed, so file could have partial record till it's compacted.
+	broken bool
+	mu     sync.RWMutex
+}
+
+// NewFileStore creates status store backed by file at path. Existing statuses are loaded from it.
+func NewFileStore(path string, opts ...Option) (*FileStore, error) {
+	o := newOptions(opts)
+
+	fs := &FileStore{
+		statuses: make(map[string]models.TaskStatus),
+		evictor:  evictor{retention: o.retention},
+		path:     path,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fs, nil
+		}
+
+		return nil, fmt.Errorf("read status file: %w", err)
+	}
+
+	truncated, err := fs.load(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record cut off by crash is dropped, otherwise the next records are appended after it.
+	if truncated {
+		if err = fs.compact(nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return fs, nil
+}
+
+// Update atomically modifies task status, creating it if needed, and writes it to file.
+func (f *FileStore) Update(_ context.Context, id string, update UpdateFunc) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	previous, ok := f.statuses[id]
+
+	status := previous
+	if !ok {
+		status = models.TaskStatus{ID: id}
+	}
+
+	update(&status)
+	f.statuses[id] = status
+
+	var err error
+
+	// Expired statuses are deleted only when compacted file without them is written.
+	expired := f.evictor.expired(f.statuses, time.Now().UTC())
+	if len(expired) > 0 || f.broken || f.records >= max(minCompactRecords, 2*len(f.statuses)) {
+		err = f.compact(expired)
+	} else {
+		err = f.append(status)
+	}
+
+	if err != nil {
+		if ok {
+			f.statuses[id] = previous
+		} else {
+			delete(f.statuses, id)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Get returns task status by task ID.
+func (f *FileStore) Get(_ context.Context, id string) (models.TaskStatus, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	status, ok := f.statuses[id]
+	if !ok {
+		return models.TaskStatus{}, ErrNotFound
+	}
+
+	return status, nil
+}
+
+// List returns statuses matching filter ordered by creation time.
+func (f *FileStore) List(_ context.Context, filter models.StatusFilter) ([]models.TaskStatus, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return filterStatuses(f.statuses, filter), nil
+}
+
+// load reads records of the file, the last record of the status wins. It reports whether the last record
+// is cut off.
+func (f *FileStore) load(data []byte) (bool, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+
+	for {
+		var status models.TaskStatus
+
+		err := decoder.Decode(&status)
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return true, nil
+		}
+
+		if err != nil {
+			return false, fmt.Errorf("decode status file: %w", err)
+		}
+
+		f.statuses[status.ID] = status
+		f.records++
+	}
+}
+
+// append writes status record at the end of the file.
+func (f *FileStore) append(status models.TaskStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("encode status: %w", err)
+	}
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, filePermissions)
+	if err != nil {
+		return fmt.Errorf("open status file: %w", err)
+	}
+
+	if _, err = file.Write(append(data, '\n')); err != nil {
+		_ = file.Close()
+		f.broken = true
+
+		return fmt.Errorf("write status file: %w", err)
+	}
+
+	if err = file.Close(); err != nil {
+		f.broken = true
+
+		return fmt.Errorf("close status file: %w", err)
+	}
+
+	f.records++
+
+	return nil
+}
+
+// compact writes record per status except expired ones into temporary file and renames it, so file is never
+// left half-written. Expired statuses are deleted after the file is replaced.
+func (f *FileStore) compact(expired []string) error {
+	var data bytes.Buffer
+
+	encoder := json.NewEncoder(&data)
+
+	skip := make(map[string]struct{}, len(expired))
+	for _, id := range expired {
+		skip[id] = struct{}{}
+	}
+
+	for id, status := range f.statuses {
+		if _, ok := skip[id]; ok {
+			continue
+		}
+
+		if err := encoder.Encode(status); err != nil {
+			return fmt.Errorf("encode statuses: %w", err)
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temporary status file: %w", err)
+	}
+
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+
+	if _, err = tmp.Write(data.Bytes()); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write status file: %w", err)
+	}
+
+	if err = tmp.Chmod(filePermissions); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("chmod status file: %w", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("close status file: %w", err)
+	}
+
+	if err = os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("rename status file: %w", err)
+	}
+
+	for _, id := range expired {
+		delete(f.statuses, id)
+	}
+
+	f.records = len(f.statuses)
+	f.broken = false
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package status
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+)
+
+// MemoryStore keeps task statuses in process memory. Statuses of finished tasks are evicted after
+// retention, see WithRetention.
+type MemoryStore struct {
+	statuses map[string]models.TaskStatus
+	evictor  evictor
+	mu       sync.RWMutex
+}
+
+// NewMemoryStore creates new in-memory status store.
+func NewMemoryStore(opts ...Option) *MemoryStore {
+	o := newOptions(opts)
+
+	return &MemoryStore{
+		statuses: make(map[string]models.TaskStatus),
+		evictor:  evictor{retention: o.retention},
+	}
+}
+
+// Update atomically modifies task status, creating it if needed.
+func (m *MemoryStore) Update(_ context.Context, id string, update UpdateFunc) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status, ok := m.statuses[id]
+	if !ok {
+		status = models.TaskStatus{ID: id}
+	}
+
+	update(&status)
+	m.statuses[id] = status
+
+	for _, expiredID := range m.evictor.expired(m.statuses, time.Now().UTC()) {
+		delete(m.statuses, expiredID)
+	}
+
+	return nil
+}
+
+// Get returns task status by task ID.
+func (m *MemoryStore) Get(_ context.Context, id string) (models.TaskStatus, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	status, ok := m.statuses[id]
+	if !ok {
+		return models.TaskStatus{}, ErrNotFound
+	}
+
+	return status, nil
+}
+
+// List returns statuses matching filter ordered by creation time.
+func (m *MemoryStore) List(_ context.Context, filter models.StatusFilter) ([]models.TaskStatus, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return filterStatuses(m.statuses, filter), nil
+}
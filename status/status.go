@@ -0,0 +1,116 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+)
+
+const (
+	// DefaultRetention is how long statuses of finished tasks are kept by default.
+	DefaultRetention = 7 * 24 * time.Hour
+	// evictInterval is how often statuses are checked for eviction.
+	evictInterval = time.Minute
+)
+
+// ErrNotFound appears when there is no status for requested task.
+var ErrNotFound = errors.New("task status not found")
+
+type options struct {
+	retention time.Duration
+}
+
+// Option is an interface for configuration options of the stores.
+type Option interface {
+	apply(o *options)
+}
+
+type retentionOption struct {
+	retention time.Duration
+}
+
+func (ro retentionOption) apply(o *options) {
+	o.retention = ro.retention
+}
+
+// WithRetention sets how long statuses of finished (succeeded, dead or cancelled) tasks are kept, default
+// is DefaultRetention. Statuses are kept forever if retention isn't positive.
+func WithRetention(retention time.Duration) Option {
+	return retentionOption{retention: retention}
+}
+
+func newOptions(opts []Option) options {
+	o := options{retention: DefaultRetention}
+
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+
+	return o
+}
+
+// UpdateFunc modifies task status. For unknown task it receives status with only ID filled.
+type UpdateFunc func(status *models.TaskStatus)
+
+// Store is an interface for task status storage which could be provided on initialization.
+type Store interface {
+	// Update atomically modifies task status, creating it if needed.
+	Update(ctx context.Context, id string, update UpdateFunc) error
+	// Get returns task status by task ID.
+	Get(ctx context.Context, id string) (models.TaskStatus, error)
+	// List returns statuses matching filter ordered by creation time.
+	List(ctx context.Context, filter models.StatusFilter) ([]models.TaskStatus, error)
+}
+
+// evictor removes statuses of tasks which finished earlier than retention ago.
+type evictor struct {
+	evictedAt time.Time
+	retention time.Duration
+}
+
+// expired returns IDs of statuses of tasks finished before retention, statuses are checked at most once
+// per evictInterval. Caller deletes them.
+func (e *evictor) expired(statuses map[string]models.TaskStatus, now time.Time) []string {
+	if e.retention <= 0 || now.Sub(e.evictedAt) < evictInterval {
+		return nil
+	}
+
+	e.evictedAt = now
+
+	var ids []string
+
+	for id, status := range statuses {
+		if !status.FinishedAt.IsZero() && now.Sub(status.FinishedAt) > e.retention {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+func filterStatuses(statuses map[string]models.TaskStatus, filter models.StatusFilter) []models.TaskStatus {
+	result := make([]models.TaskStatus, 0)
+
+	for _, status := range statuses {
+		if filter.Match(status) {
+			result = append(result, status)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].ID < result[j].ID
+		}
+
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[:filter.Limit]
+	}
+
+	return result
+}
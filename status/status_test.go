@@ -0,0 +1,195 @@
+package status
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+)
+
+func TestStores(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "statuses.json")
+
+	fileStore, err := NewFileStore(path)
+	require.NoError(t, err)
+
+	stores := map[string]Store{
+		"memory": NewMemoryStore(),
+		"file":   fileStore,
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now().UTC()
+
+			_, err := store.Get(ctx, "unknown")
+			require.ErrorIs(t, err, ErrNotFound)
+
+			for i, id := range []string{"first", "second"} {
+				err = store.Update(ctx, id, func(status *models.TaskStatus) {
+					status.Name = "test"
+					status.State = models.TaskStatePending
+					status.CreatedAt = now.Add(time.Duration(i) * time.Second)
+				})
+				require.NoError(t, err)
+			}
+
+			err = store.Update(ctx, "first", func(status *models.TaskStatus) {
+				status.State = models.TaskStateRetrying
+				status.Attempts++
+				status.LastError = "failure"
+			})
+			require.NoError(t, err)
+
+			status, err := store.Get(ctx, "first")
+			require.NoError(t, err)
+			require.Equal(t, "first", status.ID)
+			require.Equal(t, models.TaskStateRetrying, status.State)
+			require.Equal(t, 1, status.Attempts)
+			require.Equal(t, "failure", status.LastError)
+
+			statuses, err := store.List(ctx, models.StatusFilter{})
+			require.NoError(t, err)
+			require.Len(t, statuses, 2)
+			require.Equal(t, "first", statuses[0].ID)
+
+			statuses, err = store.List(ctx, models.StatusFilter{States: []models.TaskState{models.TaskStatePending}})
+			require.NoError(t, err)
+			require.Len(t, statuses, 1)
+			require.Equal(t, "second", statuses[0].ID)
+		})
+	}
+
+	reopened, err := NewFileStore(path)
+	require.NoError(t, err)
+
+	status, err := reopened.Get(context.Background(), "first")
+	require.NoError(t, err)
+	require.Equal(t, models.TaskStateRetrying, status.State)
+}
+
+func TestRetention(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "statuses.json")
+
+	fileStore, err := NewFileStore(path, WithRetention(time.Hour))
+	require.NoError(t, err)
+
+	stores := map[string]Store{
+		"memory": NewMemoryStore(WithRetention(time.Hour)),
+		"file":   fileStore,
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now().UTC()
+
+			// Expired status is evicted by the first update.
+			for _, tc := range []struct {
+				finishedAt time.Time
+				id         string
+			}{
+				{id: "expired", finishedAt: now.Add(-2 * time.Hour)},
+				{id: "recent", finishedAt: now},
+				{id: "running"},
+			} {
+				err := store.Update(ctx, tc.id, func(status *models.TaskStatus) {
+					status.FinishedAt = tc.finishedAt
+				})
+				require.NoError(t, err)
+			}
+
+			_, err := store.Get(ctx, "expired")
+			require.ErrorIs(t, err, ErrNotFound)
+
+			statuses, err := store.List(ctx, models.StatusFilter{})
+			require.NoError(t, err)
+			require.Len(t, statuses, 2)
+		})
+	}
+
+	reopened, err := NewFileStore(path)
+	require.NoError(t, err)
+
+	_, err = reopened.Get(context.Background(), "expired")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFileStoreFailedEviction(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "statuses")
+	path := filepath.Join(dir, "statuses.json")
+
+	require.NoError(t, os.Mkdir(dir, 0o700))
+
+	finishedAt := time.Now().UTC().Add(-2 * time.Hour).Format(time.RFC3339)
+	err := os.WriteFile(path, []byte(`{"id":"expired","finished_at":"`+finishedAt+`"}`+"\n"), 0o600)
+	require.NoError(t, err)
+
+	store, err := NewFileStore(path, WithRetention(time.Hour))
+	require.NoError(t, err)
+
+	// Compaction which evicts expired status can't write the file, so nothing is changed.
+	require.NoError(t, os.RemoveAll(dir))
+
+	err = store.Update(ctx, "new", func(*models.TaskStatus) {})
+	require.Error(t, err)
+
+	_, err = store.Get(ctx, "expired")
+	require.NoError(t, err)
+
+	_, err = store.Get(ctx, "new")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFileStoreRecords(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "statuses.json")
+
+	// Record cut off by crash.
+	err := os.WriteFile(path, []byte(`{"id":"first","state":"dead"}`+"\n"+`{"id":"second","sta`), 0o600)
+	require.NoError(t, err)
+
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+
+	status, err := store.Get(ctx, "first")
+	require.NoError(t, err)
+	require.Equal(t, models.TaskStateDead, status.State)
+
+	_, err = store.Get(ctx, "second")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// Updates are appended, file is compacted when it has too many outdated records.
+	for i := range minCompactRecords + 1 {
+		err = store.Update(ctx, "second", func(status *models.TaskStatus) {
+			status.Attempts = i + 1
+		})
+		require.NoError(t, err)
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Len(t, bytes.Split(bytes.TrimSpace(data), []byte("\n")), 3)
+
+	reopened, err := NewFileStore(path)
+	require.NoError(t, err)
+
+	status, err = reopened.Get(ctx, "second")
+	require.NoError(t, err)
+	require.Equal(t, minCompactRecords+1, status.Attempts)
+}
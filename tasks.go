@@ -11,44 +11,111 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/mc2soft/framework/communication"
-	defaultrequest "gitlab.local.iti.domain/mc2/golibs/legacy-framework-request"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/breaker"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/broker"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/cron"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/deadletter"
 	"gitlab.local.iti.domain/mc2/golibs/tasks/logger"
 	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
 )
 
 const (
-	defaultQueueSize   = 100
-	defaultMaxInterval = 300
+	defaultQueueSize        = 100
+	defaultMaxInterval      = 300
+	defaultExecutionTimeout = 10 * time.Minute
+	defaultLeaseTTL         = 15 * time.Second
+	defaultMaxInFlight      = 1000
 )
 
 // TaskHandler handleTask func.
 type TaskHandler func(params map[string]string) error
 
+// TaskHandlerCtx is a context-aware handleTask func. Its context is cancelled on Stop()
+// or when the application context is done and carries the per-execution deadline.
+type TaskHandlerCtx func(ctx context.Context, task TaskInfo) error
+
+// TaskInfo describes the task being executed by TaskHandlerCtx.
+type TaskInfo struct {
+	// FireTime is a logical time of the schedule occurrence, it's set for scheduled tasks only.
+	FireTime time.Time
+	Params   map[string]string
+	ID       string
+	Name     string
+	Payload  json.RawMessage
+	meta     models.Meta
+}
+
+// Meta returns metadata of the task: attempt number, origin, last error and trace context.
+func (ti TaskInfo) Meta() models.Meta {
+	return ti.meta
+}
+
 // Tasker is an interface for tasks.
 type Tasker interface {
-	RegisterHandler(taskName string, handler TaskHandler) error
-	Create(ctx context.Context, taskName string, params map[string]string) error
+	RegisterHandler(taskName string, handler TaskHandler, opts ...HandlerOption) error
+	RegisterHandlerCtx(taskName string, handler TaskHandlerCtx, opts ...HandlerOption) error
+	Create(ctx context.Context, taskName string, params map[string]string, opts ...CreateOption) (string, error)
+	CreateBatch(ctx context.Context, specs []TaskSpec) []BatchResult
+	CreateAsync(ctx context.Context, taskName string, params map[string]string, opts ...CreateOption) (*Future, error)
 	CreateScheduled(ctx context.Context, taskName string, params map[string]string,
-		startAt time.Time, period time.Duration) error
-	CreateDelayed(ctx context.Context, host, taskName string, params map[string]string, startAt time.Time) error
+		startAt time.Time, period time.Duration, opts ...ScheduleOption) (string, error)
+	CreateCron(ctx context.Context, taskName string, params map[string]string, spec string,
+		opts ...ScheduleOption) (string, error)
+	CreateDelayed(ctx context.Context, host, taskName string, params map[string]string,
+		startAt time.Time) (string, error)
+	ListScheduled(ctx context.Context) []models.ScheduledTask
+	PauseScheduled(ctx context.Context, taskName string) error
+	ResumeScheduled(ctx context.Context, taskName string) error
+	RemoveScheduled(ctx context.Context, taskName string) error
+	UpdateSchedule(ctx context.Context, taskName, spec string, opts ...ScheduleOption) error
+	Cancel(ctx context.Context, id string) error
+	Status(ctx context.Context, id string) (models.TaskStatus, error)
+	List(ctx context.Context, filter models.StatusFilter) ([]models.TaskStatus, error)
+	ListDeadLetters(ctx context.Context, filter models.DeadLetterFilter) ([]models.DeadLetter, error)
+	GetDeadLetter(ctx context.Context, id string) (models.DeadLetter, error)
+	Requeue(ctx context.Context, id string) error
+	CircuitBreakers() []breaker.Stats
 	Start() error
 	Stop()
 }
 
 type Tasks struct {
-	provider           communication.Provider
-	tasksHandlers      map[string]TaskHandler
-	scheduledTasks     map[string]models.Task
-	taskQueue          chan models.Task
+	broker             broker.Broker
+	stopCtx            context.Context
+	stop               context.CancelFunc
+	tasksHandlers      map[string]*taskHandler
+	scheduledTasks     map[string]*scheduledTask
+	pool               *workerPool
 	retryQueue         chan models.Task
 	delayedQueue       chan models.Task
+	cancelRetry        chan string
+	cancelDelayed      chan string
+	scheduleChanged    chan struct{}
+	delays             *delayTiers
+	retries            *delayTiers
+	tombstones         map[string]time.Time
+	keys               map[string]*keyState
+	queues             map[string]*namedQueue
+	routes             map[string]*namedQueue
+	inFlight           chan struct{}
+	leaseHolder        string
 	opts               *options
 	wg                 sync.WaitGroup
 	wgRetry            sync.WaitGroup
 	wgDelayed          sync.WaitGroup
+	wgScheduled        sync.WaitGroup
+	wgAsync            sync.WaitGroup
 	tasksHandlersMutex sync.RWMutex
+	queuesMutex        sync.RWMutex
+	queuesClosed       bool
+	keyOrdered         bool
+	asyncMutex         sync.Mutex
+	asyncClosed        bool
+	delayedClosed      bool
+	workersStarted     bool
 	scheduledTaskMutex sync.RWMutex
+	tombstonesMutex    sync.RWMutex
+	keysMutex          sync.Mutex
 	AreConsumersActive atomic.Bool
 }
 
@@ -76,23 +143,25 @@ func (t *Tasks) initialize() error {
 		t.opts.queueSize = defaultQueueSize
 	}
 
+	if t.opts.maxInFlight <= 0 {
+		t.opts.maxInFlight = defaultMaxInFlight
+	}
+
 	if t.opts.logger == nil {
 		t.opts.logger = new(logger.DefaultLogger)
 	}
 
-	if t.opts.retryPolicy.InitialInterval == 0 {
-		t.opts.retryPolicy.InitialInterval = time.Second
+	if t.opts.executionTimeout == 0 {
+		t.opts.executionTimeout = defaultExecutionTimeout
 	}
 
-	if t.opts.retryPolicy.BackoffCoefficient == 0 {
-		t.opts.retryPolicy.BackoffCoefficient = 2.0
-	}
+	t.opts.retryPolicy = retryPolicyWithDefaults(t.opts.retryPolicy)
 
-	if t.opts.retryPolicy.MaximumInterval == 0 {
-		t.opts.retryPolicy.MaximumInterval = defaultMaxInterval * t.opts.retryPolicy.InitialInterval
+	if t.opts.broker == nil && t.opts.provider != nil {
+		t.opts.broker = broker.NewProvider(t.opts.provider)
 	}
 
-	if t.opts.provider == nil {
+	if t.opts.broker == nil {
 		return fmt.Errorf("initialization: %w", ErrUnknownProvider)
 	}
 
@@ -100,29 +169,113 @@ func (t *Tasks) initialize() error {
 		return fmt.Errorf("initialization: %w", ErrEmptyTopic)
 	}
 
-	t.provider = t.opts.provider
+	t.broker = t.opts.broker
+
+	if orderer, ok := t.broker.(broker.KeyOrderer); ok {
+		t.keyOrdered = orderer.KeyOrdered()
+	}
 
 	if t.opts.ctx == nil {
 		return fmt.Errorf("initialization: %w", ErrUnknownContext)
 	}
 
-	t.provider.RegisterHandlerNamingFunc(func(_, _, path string) string {
-		return path
-	})
+	t.stopCtx, t.stop = context.WithCancel(t.opts.ctx)
+
+	if t.opts.leaseBackend != nil {
+		if t.opts.leaseTTL == 0 {
+			t.opts.leaseTTL = defaultLeaseTTL
+		}
+
+		t.leaseHolder = newLeaseHolder()
+	}
+
+	var err error
+
+	t.delays, err = newDelayTiers(delayTopicKind, t.opts.delayTiers)
+	if err != nil {
+		return fmt.Errorf("initialization: %w", err)
+	}
+
+	t.retries, err = newDelayTiers(retryTopicKind, t.opts.retryTiers)
+	if err != nil {
+		return fmt.Errorf("initialization: %w", err)
+	}
+
+	// Message of the task is acked when task is retried or postponed, so the task must not be kept in memory.
+	if t.opts.ackAfterProcessing && (t.delays == nil || t.retries == nil) {
+		return fmt.Errorf("initialization: %w", ErrAckWithoutDurableQueues)
+	}
+
+	// Task waiting for a worker or running is delivered again when broker stops waiting for ack.
+	if timeouter, ok := t.broker.(broker.AckTimeouter); ok && t.opts.ackAfterProcessing {
+		timeout := timeouter.AckTimeout()
+		if timeout > 0 && timeout < t.opts.executionTimeout {
+			t.opts.logger.Logf(logger.LogLevelError, "ack timeout of broker is shorter than execution timeout: %s",
+				map[string]interface{}{"execution_timeout": t.opts.executionTimeout.String()}, timeout.String())
+		}
+	}
 
-	t.provider.RegisterDefaultRequestStruct(&defaultrequest.DefaultRequest{})
+	if t.opts.deadLetterTopic != "" && t.opts.deadLetterStore == nil {
+		t.opts.deadLetterStore = deadletter.NewMemoryStore()
+	}
 
-	t.tasksHandlers = make(map[string]TaskHandler)
-	t.scheduledTasks = make(map[string]models.Task)
-	t.taskQueue = make(chan models.Task, t.opts.queueSize)
+	t.tasksHandlers = make(map[string]*taskHandler)
+	t.scheduledTasks = make(map[string]*scheduledTask)
+	t.pool = newWorkerPool(t.opts.numWorkers, t.opts.queueSize)
 	t.retryQueue = make(chan models.Task, t.opts.queueSize)
 	t.delayedQueue = make(chan models.Task, t.opts.queueSize)
+	t.cancelRetry = make(chan string, t.opts.queueSize)
+	t.cancelDelayed = make(chan string, t.opts.queueSize)
+	t.scheduleChanged = make(chan struct{}, 1)
+	t.tombstones = make(map[string]time.Time)
+	t.keys = make(map[string]*keyState)
+	t.inFlight = make(chan struct{}, t.opts.maxInFlight)
+
+	err = t.initQueues()
+	if err != nil {
+		return fmt.Errorf("initialization: %w", err)
+	}
 
 	return nil
 }
 
+// retryPolicyWithDefaults fills empty fields of the policy with default values.
+func retryPolicyWithDefaults(retryPolicy models.RetryPolicy) models.RetryPolicy {
+	if retryPolicy.InitialInterval == 0 {
+		retryPolicy.InitialInterval = time.Second
+	}
+
+	if retryPolicy.BackoffCoefficient == 0 {
+		retryPolicy.BackoffCoefficient = 2.0
+	}
+
+	if retryPolicy.MaximumInterval == 0 {
+		retryPolicy.MaximumInterval = defaultMaxInterval * retryPolicy.InitialInterval
+	}
+
+	if retryPolicy.Strategy == "" {
+		retryPolicy.Strategy = models.BackoffExponential
+	}
+
+	if retryPolicy.Jitter == "" {
+		retryPolicy.Jitter = models.JitterNone
+	}
+
+	return retryPolicy
+}
+
 func (t *Tasks) Start() error {
-	err := t.provider.RegisterHandler("", t.opts.topic, t.handleTask)
+	err := t.subscribe(t.opts.topic, t.handleTask)
+	if err != nil {
+		return fmt.Errorf("initialization: %w", err)
+	}
+
+	err = t.subscribeQueues()
+	if err != nil {
+		return fmt.Errorf("initialization: %w", err)
+	}
+
+	err = t.registerDelayHandlers()
 	if err != nil {
 		return fmt.Errorf("initialization: %w", err)
 	}
@@ -133,60 +286,181 @@ func (t *Tasks) Start() error {
 	return nil
 }
 
-func (t *Tasks) RegisterHandler(taskName string, handler TaskHandler) error {
+func (t *Tasks) RegisterHandler(taskName string, handler TaskHandler, opts ...HandlerOption) error {
+	return t.registerHandler(ErrRegisterHandler, taskName, func(_ context.Context, task TaskInfo) error {
+		return handler(task.Params)
+	}, opts)
+}
+
+// RegisterHandlerCtx registers context-aware handler for the task.
+func (t *Tasks) RegisterHandlerCtx(taskName string, handler TaskHandlerCtx, opts ...HandlerOption) error {
+	return t.registerHandler(ErrRegisterHandlerCtx, taskName, handler, opts)
+}
+
+func (t *Tasks) registerHandler(errMethod error, taskName string, handler TaskHandlerCtx, opts []HandlerOption) error {
 	t.tasksHandlersMutex.Lock()
 	defer t.tasksHandlersMutex.Unlock()
 
 	_, ok := t.tasksHandlers[taskName]
 	if ok {
-		return fmt.Errorf("%w: %w: %s", ErrRegisterHandler, ErrTaskNameAlreadyRegistered, taskName)
+		return fmt.Errorf("%w: %w: %s", errMethod, ErrTaskNameAlreadyRegistered, taskName)
+	}
+
+	ho := newHandlerOptions(opts)
+
+	h := &taskHandler{
+		handler:     handler,
+		opts:        ho,
+		retryPolicy: t.opts.retryPolicy,
+		timeout:     t.opts.executionTimeout,
+	}
+
+	if ho.queue != "" {
+		h.queue, ok = t.queues[ho.queue]
+		if !ok {
+			return fmt.Errorf("%w: %w: %s", errMethod, ErrUnknownQueue, ho.queue)
+		}
+
+		h.retryPolicy = h.queue.retryPolicy
+		t.routes[taskName] = h.queue
+	}
+
+	if ho.retryPolicy != nil {
+		h.retryPolicy = retryPolicyWithDefaults(*ho.retryPolicy)
+	}
+
+	if ho.timeout > 0 {
+		h.timeout = ho.timeout
+	}
+
+	if ho.breaker != nil {
+		h.breaker = t.newCircuitBreaker(taskName, *ho.breaker)
+	}
+
+	if ho.concurrency > 0 {
+		queueSize := ho.queueSize
+		if queueSize == 0 {
+			queueSize = t.opts.queueSize
+		}
+
+		h.pool = newWorkerPool(ho.concurrency, queueSize)
+
+		// Workers of handlers registered before Start are started by startWorkers.
+		if t.workersStarted {
+			t.startHandlerWorkers(t.opts.ctx, h)
+		}
 	}
 
-	t.tasksHandlers[taskName] = handler
+	t.tasksHandlers[taskName] = h
 
 	return nil
 }
 
-func (t *Tasks) Create(ctx context.Context, taskName string, params map[string]string) error {
+// Create creates task for immediate processing and returns its ID.
+func (t *Tasks) Create(
+	ctx context.Context,
+	taskName string,
+	params map[string]string,
+	opts ...CreateOption,
+) (string, error) {
+	task := newTask(ctx, taskName, params, newCreateOptions(opts))
+	t.checkKey(task)
+
+	t.updateStatus(ctx, task, func(status *models.TaskStatus) {
+		status.State = models.TaskStatePending
+	})
+
+	err := t.publish(ctx, task)
+	if err != nil {
+		t.markDead(ctx, task, err)
+		return "", fmt.Errorf("%w: %w", ErrCreate, err)
+	}
+
+	return task.ID, nil
+}
+
+// newTask prepares task for immediate processing.
+func newTask(ctx context.Context, taskName string, params map[string]string, co *createOptions) models.Task {
 	task := models.Task{
+		Meta:      newMeta(ctx, models.OriginCreate),
+		ID:        newTaskID(),
 		Name:      taskName,
 		Params:    params,
+		Payload:   co.payload,
+		Key:       co.key,
 		StartTime: time.Now().UTC(),
+		Version:   models.TaskVersion,
 	}
 
 	if task.Params == nil {
 		task.Params = map[string]string{}
 	}
 
-	taskRaw, err := json.Marshal(task)
-	if err != nil {
-		return fmt.Errorf("%w :%w", ErrCreate, err)
+	return task
+}
+
+// checkKey logs error when task has partition key, but broker doesn't deliver tasks of the key in order.
+func (t *Tasks) checkKey(task models.Task) {
+	if task.Key == "" || t.keyOrdered {
+		return
 	}
 
-	event := defaultrequest.New(
-		ctx,
-		"",
-		t.opts.topic,
-		nil,
-		taskRaw,
-	)
+	t.opts.logger.Logf(logger.LogLevelError, "partition key is ignored by broker: %s",
+		map[string]interface{}{"task_name": task.Name, "task_id": task.ID}, task.Key)
+}
+
+// publish sends prepared task to the topic of its queue.
+func (t *Tasks) publish(ctx context.Context, task models.Task) error {
+	return t.publishTo(ctx, t.topicFor(task.Name), task, task)
+}
+
+// publishTo publishes task or another message of the task (e.g. dead letter) to the topic. Partition
+// key and headers of the message are taken from the task.
+func (t *Tasks) publishTo(ctx context.Context, topic string, task models.Task, message any) error {
+	msg, err := newMessage(topic, task, message)
+	if err != nil {
+		return err
+	}
 
-	err = t.provider.Send(event)
+	err = t.broker.Publish(ctx, msg)
 	if err != nil {
-		return fmt.Errorf("%w: %w", ErrCreate, err)
+		return fmt.Errorf("send task: %w", err)
 	}
 
 	return nil
 }
 
+// newMessage creates broker message of the task or another message of the task.
+func newMessage(topic string, task models.Task, message any) (*broker.Message, error) {
+	taskRaw, err := json.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("marshal message: %w", err)
+	}
+
+	return &broker.Message{
+		Headers: taskHeaders(task),
+		Topic:   topic,
+		Key:     task.Key,
+		Body:    taskRaw,
+	}, nil
+}
+
+// CreateScheduled creates periodic task and returns ID of the schedule. Every occurrence
+// of the schedule is created as a separate task with its own ID.
 func (t *Tasks) CreateScheduled(
 	_ context.Context,
 	taskName string,
 	params map[string]string,
 	startAt time.Time,
 	period time.Duration,
-) error {
+	opts ...ScheduleOption,
+) (string, error) {
+	if period <= 0 {
+		return "", fmt.Errorf("%w: %w: %s", ErrCreateScheduled, ErrInvalidPeriod, period)
+	}
+
 	task := models.Task{
+		ID:             newTaskID(),
 		Name:           taskName,
 		Params:         params,
 		Period:         period,
@@ -197,63 +471,82 @@ func (t *Tasks) CreateScheduled(
 		task.Params = map[string]string{}
 	}
 
-	task.Params["scheduled"] = "true"
-
-	t.scheduledTaskMutex.Lock()
-	defer t.scheduledTaskMutex.Unlock()
-
-	_, ok := t.scheduledTasks[taskName]
-	if ok {
-		return fmt.Errorf("%w: %w: %s", ErrCreateScheduled, ErrTaskNameAlreadyRegistered, taskName)
+	err := t.addScheduled(task, cron.Every(period), "@every "+period.String(), newScheduleOptions(opts))
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrCreateScheduled, err)
 	}
 
-	t.scheduledTasks[taskName] = task
-
-	return nil
+	return task.ID, nil
 }
 
-// CreateDelayed creates a delayed task that will be executed at the specified time.
-// host parameter is reserved for future use (e.g., for distributed task routing).
+// CreateDelayed creates a delayed task that will be executed at the specified time and
+// returns its ID. host parameter is reserved for future use (e.g., for distributed task routing).
 func (t *Tasks) CreateDelayed(
 	ctx context.Context,
 	host, taskName string,
 	params map[string]string,
 	startAt time.Time,
-) error {
+) (string, error) {
 	// Validate startAt time
 	if startAt.Before(time.Now().UTC()) {
-		return fmt.Errorf("%w: start time is in the past", ErrCreateDelayed)
+		return "", fmt.Errorf("%w: start time is in the past", ErrCreateDelayed)
 	}
 
 	task := models.Task{
+		Meta:      newMeta(ctx, models.OriginDelayed),
+		ID:        newTaskID(),
 		Name:      taskName,
 		Params:    params,
 		StartTime: startAt,
 		Host:      host,
+		Version:   models.TaskVersion,
 	}
 
 	if task.Params == nil {
 		task.Params = map[string]string{}
 	}
 
-	// Mark as delayed task
-	task.Params["delayed"] = "true"
-
 	t.opts.logger.Logf(logger.LogLevelInfo,
 		"creating delayed task: %s, start time: %s",
-		nil,
+		map[string]interface{}{"task_name": taskName, "task_id": task.ID},
 		taskName,
 		startAt.Format(time.RFC3339),
 	)
 
+	if t.delays != nil {
+		err := t.publishDelayed(ctx, t.delays, task)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrCreateDelayed, err)
+		}
+
+		t.updateStatus(ctx, task, func(status *models.TaskStatus) {
+			status.State = models.TaskStatePending
+			status.NextRunAt = startAt
+		})
+
+		return task.ID, nil
+	}
+
+	t.queuesMutex.RLock()
+	defer t.queuesMutex.RUnlock()
+
+	if t.delayedClosed {
+		return "", fmt.Errorf("%w: tasks are stopped", ErrCreateDelayed)
+	}
+
 	// Add to delayed queue
 	select {
 	case t.delayedQueue <- task:
-		return nil
+		t.updateStatus(ctx, task, func(status *models.TaskStatus) {
+			status.State = models.TaskStatePending
+			status.NextRunAt = startAt
+		})
+
+		return task.ID, nil
 	case <-ctx.Done():
-		return fmt.Errorf("%w: %w", ErrCreateDelayed, ctx.Err())
+		return "", fmt.Errorf("%w: %w", ErrCreateDelayed, ctx.Err())
 	default:
-		return fmt.Errorf("%w: delayed queue is full", ErrCreateDelayed)
+		return "", fmt.Errorf("%w: delayed queue is full", ErrCreateDelayed)
 	}
 }
 
@@ -263,16 +556,48 @@ func (t *Tasks) Stop() {
 	// метод типа UnRegisterHandler()  stop subscriptions kafka, то не нужно AreConsumersActive
 	t.AreConsumersActive.Store(false)
 
+	// Tasks created by CreateAsync are delivered before shutdown.
+	t.asyncMutex.Lock()
+	t.asyncClosed = true
+	t.asyncMutex.Unlock()
+
+	t.wgAsync.Wait()
+
+	// Parked tasks are already consumed, so they are processed before shutdown even if their keys are
+	// still blocked by retries.
+	t.releaseKeys()
 	t.waitForTaskQueueFree(t.opts.ctx)
 
-	close(t.taskQueue)
+	t.queuesMutex.Lock()
+	t.queuesClosed = true
+	t.queuesMutex.Unlock()
+
+	t.pool.close()
+	t.closeHandlerQueues()
+	t.closeQueues()
+	// Notify handlers which are still running and scheduled task worker about shutdown.
+	t.stop()
 	t.wg.Wait()
 
 	close(t.retryQueue)
 	t.wgRetry.Wait()
 
+	t.queuesMutex.Lock()
+	t.delayedClosed = true
+	t.queuesMutex.Unlock()
+
 	close(t.delayedQueue)
 	t.wgDelayed.Wait()
+
+	t.wgScheduled.Wait()
+	t.releaseSchedulerLease()
+
+	// Broker is closed after remaining retries and delayed tasks are published. Messages which are
+	// not acked yet, e.g. queued tasks dropped on shutdown, are released and delivered again.
+	err := t.broker.Close()
+	if err != nil {
+		t.opts.logger.Logf(logger.LogLevelError, "close broker error: %s", nil, err.Error())
+	}
 }
 
 func (t *Tasks) waitForTaskQueueFree(ctx context.Context) {
@@ -284,10 +609,11 @@ func (t *Tasks) waitForTaskQueueFree(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			t.opts.logger.Logf(logger.LogLevelInfo, "waiting for task queue to free: %d", nil,
-				len(t.taskQueue))
+			queued := t.queuedTasks()
+
+			t.opts.logger.Logf(logger.LogLevelInfo, "waiting for task queue to free: %d", nil, queued)
 
-			if len(t.taskQueue) == 0 {
+			if queued == 0 {
 				return
 			}
 		}
@@ -2,16 +2,26 @@ package tasks
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	comContext "github.com/mc2soft/framework/communication/context"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/breaker"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/broker"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/cron"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/deadletter"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/lease"
 	"gitlab.local.iti.domain/mc2/golibs/tasks/logger"
 	"gitlab.local.iti.domain/mc2/golibs/tasks/mocks"
 	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+	"gitlab.local.iti.domain/mc2/golibs/tasks/status"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -48,7 +58,7 @@ func (ts *TasksSuite) TestTasks_Create() {
 	err = tasker.RegisterHandler("test_error", testTaskWithError)
 	ts.Require().NoError(err)
 
-	err = tasker.Create(context.Background(), "test", map[string]string{
+	_, err = tasker.Create(context.Background(), "test", map[string]string{
 		"data": "dummy data",
 	})
 
@@ -56,7 +66,7 @@ func (ts *TasksSuite) TestTasks_Create() {
 
 	ts.Run("Tasks with retry", func() {
 		for i := 0; i < 100; i++ {
-			err = tasker.Create(context.Background(), "test_error", map[string]string{
+			_, err = tasker.Create(context.Background(), "test_error", map[string]string{
 				"data": fmt.Sprintf("dummy task-%d", i+1),
 			})
 
@@ -93,20 +103,186 @@ func (ts *TasksSuite) TestTasks_CreateScheduled() {
 	timeTask := time.Date(now.Year(), now.Month(), now.Day(), 1, 0, 0, 0, tz)
 
 	ts.Run("OK", func() {
-		err = tasker.CreateScheduled(context.Background(), "test", nil, time.Now(), time.Second)
+		_, err = tasker.CreateScheduled(context.Background(), "test", nil, time.Now(), time.Second)
 		ts.Require().NoError(err)
 	})
 
 	ts.Run("Error", func() {
-		err = tasker.CreateScheduled(context.Background(), "test_err", nil, timeTask, time.Hour)
+		_, err = tasker.CreateScheduled(context.Background(), "test_err", nil, timeTask, time.Hour)
 		ts.Require().NoError(err)
 
-		err = tasker.CreateScheduled(context.Background(), "test_err", nil, timeTask, time.Minute)
+		_, err = tasker.CreateScheduled(context.Background(), "test_err", nil, timeTask, time.Minute)
 		ts.Require().Error(err)
 	})
 
-	time.Sleep(defaultScheduledTaskDuration)
+	ts.Run("Cron", func() {
+		id, err := tasker.CreateCron(context.Background(), "test_cron", nil, "15 3 * * 1-5", WithTimezone(tz))
+		ts.Require().NoError(err)
+		ts.Require().NotEmpty(id)
+
+		_, err = tasker.CreateCron(context.Background(), "test_cron", nil, "@daily")
+		ts.Require().ErrorIs(err, ErrTaskNameAlreadyRegistered)
+
+		_, err = tasker.CreateCron(context.Background(), "test_cron_invalid", nil, "61 * * * *")
+		ts.Require().ErrorIs(err, cron.ErrInvalidSpec)
+		ts.Require().ErrorIs(err, ErrCreateCron)
+	})
+
+	ts.Run("Manage", func() {
+		scheduled := tasker.ListScheduled(context.Background())
+		ts.Require().Len(scheduled, 3)
+		ts.Require().Equal("test_cron", scheduled[1].Name)
+		ts.Require().Equal("15 3 * * 1-5", scheduled[1].Spec)
+		ts.Require().Equal("@every 1h0m0s", scheduled[2].Spec)
+
+		ts.Require().NoError(tasker.PauseScheduled(context.Background(), "test_cron"))
+		ts.Require().True(tasker.ListScheduled(context.Background())[1].Paused)
+
+		ts.Require().NoError(tasker.ResumeScheduled(context.Background(), "test_cron"))
+		ts.Require().False(tasker.ListScheduled(context.Background())[1].Paused)
+
+		err = tasker.UpdateSchedule(context.Background(), "test_cron", "@every 2h")
+		ts.Require().NoError(err)
+
+		updated := tasker.ListScheduled(context.Background())[1]
+		ts.Require().Equal("@every 2h", updated.Spec)
+		ts.Require().WithinDuration(time.Now().Add(2*time.Hour), updated.NextRunAt, time.Second)
+
+		err = tasker.UpdateSchedule(context.Background(), "test_cron", "0 0 30 2 *")
+		ts.Require().ErrorIs(err, cron.ErrInvalidSpec)
+
+		ts.Require().NoError(tasker.RemoveScheduled(context.Background(), "test_cron"))
+		ts.Require().Len(tasker.ListScheduled(context.Background()), 2)
+
+		for _, err := range []error{
+			tasker.PauseScheduled(context.Background(), "test_cron"),
+			tasker.ResumeScheduled(context.Background(), "test_cron"),
+			tasker.RemoveScheduled(context.Background(), "test_cron"),
+			tasker.UpdateSchedule(context.Background(), "test_cron", "@daily"),
+		} {
+			ts.Require().ErrorIs(err, ErrScheduledTaskNotFound)
+		}
+
+		// Removed name can be registered again.
+		_, err = tasker.CreateCron(context.Background(), "test_cron", nil, "@hourly")
+		ts.Require().NoError(err)
+	})
+
+	time.Sleep(2 * time.Second)
+	tasker.Stop()
+}
+
+func (ts *TasksSuite) TestTasks_ScheduledPrecision() {
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithProvider(mocks.New(), "test"),
+		WithNumWorkers(1),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	lateness := make(chan time.Duration, 100)
+
+	err = tasker.RegisterHandlerCtx("precise", func(_ context.Context, task TaskInfo) error {
+		lateness <- time.Since(task.FireTime)
+		return nil
+	})
+	ts.Require().NoError(err)
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	_, err = tasker.CreateScheduled(context.Background(), "precise", nil, time.Now().UTC(), 200*time.Millisecond)
+	ts.Require().NoError(err)
+
+	time.Sleep(1100 * time.Millisecond)
 	tasker.Stop()
+	close(lateness)
+
+	ts.Require().GreaterOrEqual(len(lateness), 4)
+
+	for late := range lateness {
+		ts.Require().Less(late, 50*time.Millisecond)
+	}
+}
+
+func (ts *TasksSuite) TestTasks_Misfire() {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	// Four of five occurrences were missed, the last one is still within threshold.
+	start := now.Add(-4*time.Minute - 30*time.Second)
+
+	newEntry := func(policy models.MisfirePolicy) *scheduledTask {
+		so := newScheduleOptions([]ScheduleOption{WithMisfirePolicy(policy)})
+
+		return &scheduledTask{
+			schedule: cron.Every(time.Minute),
+			task:     models.Task{TimeOfNextExec: start},
+			opts:     *so,
+		}
+	}
+
+	for _, tc := range []struct {
+		policy models.MisfirePolicy
+		fired  []time.Time
+	}{
+		{policy: models.MisfireFireAll, fired: []time.Time{
+			start, start.Add(time.Minute), start.Add(2 * time.Minute), start.Add(3 * time.Minute), start.Add(4 * time.Minute),
+		}},
+		{policy: models.MisfireFireOnce, fired: []time.Time{start.Add(3 * time.Minute), start.Add(4 * time.Minute)}},
+		{policy: models.MisfireSkip, fired: []time.Time{start.Add(4 * time.Minute)}},
+	} {
+		ts.Run(string(tc.policy), func() {
+			entry := newEntry(tc.policy)
+
+			fired, missed := entry.advance(now)
+			ts.Require().Equal(tc.fired, fired)
+			ts.Require().Equal(4, missed)
+			ts.Require().Equal(start.Add(5*time.Minute), entry.task.TimeOfNextExec)
+
+			fired, missed = entry.advance(now)
+			ts.Require().Empty(fired)
+			ts.Require().Zero(missed)
+		})
+	}
+
+	ts.Run("Skip all", func() {
+		entry := newEntry(models.MisfireSkip)
+		entry.opts.misfireThreshold = 10 * time.Second
+
+		fired, missed := entry.advance(now.Add(time.Hour))
+		ts.Require().Empty(fired)
+		ts.Require().Equal(65, missed)
+		ts.Require().Equal(start.Add(65*time.Minute), entry.task.TimeOfNextExec)
+	})
+
+	ts.Run("Unknown policy", func() {
+		tasker, err := New(WithContext(context.Background()), WithProvider(mocks.New(), "test"))
+		ts.Require().NoError(err)
+
+		_, err = tasker.CreateScheduled(context.Background(), "test", nil, now, time.Minute,
+			WithMisfirePolicy("unknown"))
+		ts.Require().ErrorIs(err, ErrUnknownMisfirePolicy)
+	})
+
+	ts.Run("Fire once all missed", func() {
+		entry := newEntry(models.MisfireFireOnce)
+		entry.opts.misfireThreshold = 10 * time.Second
+
+		fired, missed := entry.advance(now)
+		ts.Require().Equal([]time.Time{start.Add(4 * time.Minute)}, fired)
+		ts.Require().Equal(5, missed)
+	})
+
+	ts.Run("Invalid period", func() {
+		tasker, err := New(WithContext(context.Background()), WithProvider(mocks.New(), "test"))
+		ts.Require().NoError(err)
+
+		for _, period := range []time.Duration{0, -time.Minute} {
+			_, err = tasker.CreateScheduled(context.Background(), "test", nil, now, period)
+			ts.Require().ErrorIs(err, ErrCreateScheduled)
+			ts.Require().ErrorIs(err, ErrInvalidPeriod)
+		}
+	})
 }
 
 func (ts *TasksSuite) TestTasks_CreateDelayed() {
@@ -145,7 +321,7 @@ func (ts *TasksSuite) TestTasks_CreateDelayed() {
 		executedTasks = []string{}
 		startTime := time.Now().UTC().Add(2 * time.Second)
 
-		err = tasker.CreateDelayed(
+		_, err = tasker.CreateDelayed(
 			context.Background(),
 			"localhost",
 			"delayed_test",
@@ -168,7 +344,7 @@ func (ts *TasksSuite) TestTasks_CreateDelayed() {
 		now := time.Now().UTC()
 
 		// Create 3 tasks with different delays
-		err = tasker.CreateDelayed(
+		_, err = tasker.CreateDelayed(
 			context.Background(),
 			"localhost",
 			"delayed_test",
@@ -177,7 +353,7 @@ func (ts *TasksSuite) TestTasks_CreateDelayed() {
 		)
 		ts.Require().NoError(err)
 
-		err = tasker.CreateDelayed(
+		_, err = tasker.CreateDelayed(
 			context.Background(),
 			"localhost",
 			"delayed_test",
@@ -186,7 +362,7 @@ func (ts *TasksSuite) TestTasks_CreateDelayed() {
 		)
 		ts.Require().NoError(err)
 
-		err = tasker.CreateDelayed(
+		_, err = tasker.CreateDelayed(
 			context.Background(),
 			"localhost",
 			"delayed_test",
@@ -210,7 +386,7 @@ func (ts *TasksSuite) TestTasks_CreateDelayed() {
 	ts.Run("Error - Past time", func() {
 		pastTime := time.Now().UTC().Add(-1 * time.Hour)
 
-		err = tasker.CreateDelayed(
+		_, err = tasker.CreateDelayed(
 			context.Background(),
 			"localhost",
 			"delayed_test",
@@ -225,7 +401,7 @@ func (ts *TasksSuite) TestTasks_CreateDelayed() {
 		executedTasks = []string{}
 		startTime := time.Now().UTC().Add(1 * time.Second)
 
-		err = tasker.CreateDelayed(
+		_, err = tasker.CreateDelayed(
 			context.Background(),
 			"host-server-01",
 			"delayed_test",
@@ -269,7 +445,7 @@ func (ts *TasksSuite) TestTasks_CreateDelayed() {
 
 		startTime := time.Now().UTC().Add(1 * time.Second)
 
-		err = tasker.CreateDelayed(
+		_, err = tasker.CreateDelayed(
 			context.Background(),
 			"localhost",
 			"delayed_test_retry",
@@ -291,74 +467,280 @@ func (ts *TasksSuite) TestTasks_CreateDelayed() {
 	tasker.Stop()
 }
 
-func (ts *TasksSuite) TestTasks_CreateDelayed_CancelContext() {
-	mockProvider := mocks.New()
+func (ts *TasksSuite) TestTasks_DurableDelays() {
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithProvider(mocks.NewAsync(), "test"),
+		WithNumWorkers(1),
+		WithDurableDelays(100*time.Millisecond, 500*time.Millisecond),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
 
-	ctx, cancel := context.WithCancel(context.Background())
+	executed := make(chan time.Time, 2)
+
+	err = tasker.RegisterHandler("durable_delay", func(map[string]string) error {
+		executed <- time.Now().UTC()
+		return nil
+	})
+	ts.Require().NoError(err)
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	ts.Run("Tiers", func() {
+		startAt := time.Now().UTC().Add(1200 * time.Millisecond)
+
+		_, err = tasker.CreateDelayed(context.Background(), "localhost", "durable_delay", nil, startAt)
+		ts.Require().NoError(err)
+
+		ts.Require().Len(tasker.(*Tasks).delayedQueue, 0)
+
+		select {
+		case executedAt := <-executed:
+			ts.Require().False(executedAt.Before(startAt))
+			ts.Require().WithinDuration(startAt, executedAt, 200*time.Millisecond)
+		case <-time.After(3 * time.Second):
+			ts.Fail("delayed task was not executed")
+		}
+	})
+
+	ts.Run("Postponed by consumer", func() {
+		startAt := time.Now().UTC().Add(300 * time.Millisecond)
+
+		err = tasker.(*Tasks).publish(context.Background(), models.Task{
+			ID:        newTaskID(),
+			Name:      "durable_delay",
+			StartTime: startAt,
+		})
+		ts.Require().NoError(err)
+
+		select {
+		case executedAt := <-executed:
+			ts.Require().False(executedAt.Before(startAt))
+		case <-time.After(2 * time.Second):
+			ts.Fail("postponed task was not executed")
+		}
+	})
+
+	ts.Run("Delivery context is done", func() {
+		t := tasker.(*Tasks)
+		now := time.Now().UTC()
+
+		body, err := json.Marshal(models.Task{
+			ID:         newTaskID(),
+			Name:       "durable_delay",
+			StartTime:  now.Add(time.Hour),
+			EnqueuedAt: now,
+		})
+		ts.Require().NoError(err)
+
+		// Consumer is released, e.g. on rebalance, message is kept in the topic.
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		err = t.delayHandler(t.delays, time.Hour)(ctx, &broker.Message{Body: body})
+		ts.Require().ErrorIs(err, errRedeliver)
+		ts.Require().Less(time.Since(now), time.Second)
+	})
+
+	ts.Run("Invalid tier", func() {
+		_, err = New(WithContext(context.Background()), WithProvider(mocks.New(), "test"), WithDurableDelays(-time.Second))
+		ts.Require().ErrorIs(err, ErrInvalidDelayTier)
+	})
+
+	tasker.Stop()
+}
 
+func (ts *TasksSuite) TestTasks_DurableRetries() {
 	tasker, err := New(
-		WithContext(ctx),
-		WithProvider(mockProvider, "test"),
+		WithContext(context.Background()),
+		WithProvider(mocks.NewAsync(), "test"),
+		WithNumWorkers(1),
 		WithRetryPolicy(models.RetryPolicy{
+			InitialInterval:    400 * time.Millisecond,
 			BackoffCoefficient: 2.0,
 			MaximumAttempts:    3,
 		}),
-		WithNumWorkers(1),
-		WithQueueSize(1),
+		WithDurableRetries(100*time.Millisecond, 300*time.Millisecond),
 		WithLogger(logger.DefaultLogger{}),
 	)
 	ts.Require().NoError(err)
 
-	err = tasker.RegisterHandler("test", testTask)
+	attempts := make(chan time.Time, 3)
+
+	err = tasker.RegisterHandler("durable_retry", func(map[string]string) error {
+		attempts <- time.Now()
+		if len(attempts) == 1 {
+			return errors.New("first attempt fails")
+		}
+
+		return nil
+	})
 	ts.Require().NoError(err)
 
 	err = tasker.Start()
 	ts.Require().NoError(err)
 
-	// Fill the queue
-	err = tasker.CreateDelayed(
-		ctx,
-		"localhost",
-		"test",
-		map[string]string{"task_id": "task_1"},
-		time.Now().UTC().Add(1*time.Second),
-	)
+	_, err = tasker.Create(context.Background(), "durable_retry", nil)
 	ts.Require().NoError(err)
 
-	// Cancel context
-	cancel()
+	ts.Require().Eventually(func() bool { return len(attempts) == 2 }, 2*time.Second, 10*time.Millisecond)
 
-	// Try to create another delayed task with canceled context
-	err = tasker.CreateDelayed(
-		ctx,
-		"localhost",
-		"test",
-		map[string]string{"task_id": "task_2"},
-		time.Now().UTC().Add(1*time.Second),
-	)
-	ts.Require().Error(err)
+	first, second := <-attempts, <-attempts
+	ts.Require().GreaterOrEqual(second.Sub(first), 400*time.Millisecond)
+	ts.Require().Less(second.Sub(first), 600*time.Millisecond)
 
 	tasker.Stop()
 }
 
-func (ts *TasksSuite) TestTasks_CreateDelayed_QueueFull() {
+func (ts *TasksSuite) TestTasks_DeadLetter() {
 	mockProvider := mocks.New()
 
+	published := make(chan models.DeadLetter, 1)
+
+	err := mockProvider.RegisterHandler("", "test.dlq", func(ctx comContext.Context) error {
+		var letter models.DeadLetter
+
+		err := json.NewDecoder(ctx.Body()).Decode(&letter)
+		published <- letter
+
+		return err
+	})
+	ts.Require().NoError(err)
+
 	tasker, err := New(
 		WithContext(context.Background()),
 		WithProvider(mockProvider, "test"),
+		WithNumWorkers(1),
 		WithRetryPolicy(models.RetryPolicy{
-			BackoffCoefficient: 2.0,
-			MaximumAttempts:    3,
+			InitialInterval:    50 * time.Millisecond,
+			BackoffCoefficient: 1.0,
+			MaximumAttempts:    2,
 		}),
+		WithStatusStore(status.NewMemoryStore()),
+		WithDeadLetterTopic("test.dlq"),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	var fixed atomic.Bool
+
+	err = tasker.RegisterHandler("dead_letter", func(map[string]string) error {
+		if fixed.Load() {
+			return nil
+		}
+
+		return errors.New("always fails")
+	})
+	ts.Require().NoError(err)
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	id, err := tasker.Create(context.Background(), "dead_letter", map[string]string{"key": "value"})
+	ts.Require().NoError(err)
+
+	var letter models.DeadLetter
+
+	select {
+	case letter = <-published:
+	case <-time.After(2 * time.Second):
+		ts.FailNow("dead letter was not published")
+	}
+
+	ts.Require().Equal(id, letter.Task.ID)
+	ts.Require().Equal("value", letter.Task.Params["key"])
+	ts.Require().Equal("always fails", letter.Error)
+	ts.Require().Equal(models.DeadLetterMaxAttempts, letter.Reason)
+	ts.Require().Len(letter.Attempts, 3)
+	ts.Require().Equal(letter.Attempts[0].StartedAt, letter.FirstAttemptAt)
+
+	letters, err := tasker.ListDeadLetters(context.Background(), models.DeadLetterFilter{Name: "dead_letter"})
+	ts.Require().NoError(err)
+	ts.Require().Len(letters, 1)
+
+	taskStatus, err := tasker.Status(context.Background(), id)
+	ts.Require().NoError(err)
+	ts.Require().Equal(models.TaskStateDead, taskStatus.State)
+
+	fixed.Store(true)
+
+	err = tasker.Requeue(context.Background(), id)
+	ts.Require().NoError(err)
+
+	ts.Require().Eventually(func() bool {
+		taskStatus, err := tasker.Status(context.Background(), id)
+		return err == nil && taskStatus.State == models.TaskStateSucceeded && taskStatus.Attempts == 1
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = tasker.GetDeadLetter(context.Background(), id)
+	ts.Require().ErrorIs(err, deadletter.ErrNotFound)
+
+	err = tasker.Requeue(context.Background(), id)
+	ts.Require().ErrorIs(err, ErrRequeue)
+
+	tasker.Stop()
+
+	tasker, err = New(WithContext(context.Background()), WithProvider(mocks.New(), "test"))
+	ts.Require().NoError(err)
+
+	_, err = tasker.ListDeadLetters(context.Background(), models.DeadLetterFilter{})
+	ts.Require().ErrorIs(err, ErrDeadLetterNotSet)
+}
+
+func (ts *TasksSuite) TestTasks_HistoryLimit() {
+	var history []models.Attempt
+
+	for i := range 15 {
+		history = appendAttempt(history, models.Attempt{Error: strconv.Itoa(i + 1)})
+	}
+
+	ts.Require().Len(history, maxHistory)
+	ts.Require().Equal("1", history[0].Error)
+	ts.Require().Equal("7", history[1].Error)
+	ts.Require().Equal("15", history[maxHistory-1].Error)
+}
+
+func (ts *TasksSuite) TestTasks_RetryControl() {
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithProvider(mocks.New(), "test"),
 		WithNumWorkers(1),
-		WithQueueSize(2), // Small queue size
+		WithRetryPolicy(models.RetryPolicy{
+			InitialInterval:    10 * time.Millisecond,
+			BackoffCoefficient: 1.0,
+			MaximumAttempts:    5,
+		}),
+		WithStatusStore(status.NewMemoryStore()),
 		WithLogger(logger.DefaultLogger{}),
 	)
 	ts.Require().NoError(err)
 
-	err = tasker.RegisterHandler("test", func(params map[string]string) error {
-		time.Sleep(5 * time.Second) // Slow handler
+	errValidation := errors.New("validation error")
+
+	var permanentCalls, predicateCalls atomic.Int32
+
+	err = tasker.RegisterHandler("permanent", func(map[string]string) error {
+		permanentCalls.Add(1)
+		return Permanent(errValidation)
+	})
+	ts.Require().NoError(err)
+
+	err = tasker.RegisterHandler("predicate", func(map[string]string) error {
+		predicateCalls.Add(1)
+		return fmt.Errorf("wrapped: %w", errValidation)
+	}, RetryIf(func(err error) bool { return !errors.Is(err, errValidation) }))
+	ts.Require().NoError(err)
+
+	retryAfterCalls := make(chan time.Time, 2)
+
+	err = tasker.RegisterHandler("retry_after", func(map[string]string) error {
+		retryAfterCalls <- time.Now()
+		if len(retryAfterCalls) == 1 {
+			return RetryAfter(errors.New("rate limited"), 300*time.Millisecond)
+		}
+
 		return nil
 	})
 	ts.Require().NoError(err)
@@ -366,32 +748,1726 @@ func (ts *TasksSuite) TestTasks_CreateDelayed_QueueFull() {
 	err = tasker.Start()
 	ts.Require().NoError(err)
 
-	// Fill the queue
-	for i := 0; i < 2; i++ {
-		err = tasker.CreateDelayed(
-			context.Background(),
-			"localhost",
-			"test",
-			map[string]string{"task_id": fmt.Sprintf("task_%d", i)},
-			time.Now().UTC().Add(1*time.Second),
-		)
+	for _, name := range []string{"permanent", "predicate"} {
+		id, err := tasker.Create(context.Background(), name, nil)
 		ts.Require().NoError(err)
+
+		ts.Require().Eventually(func() bool {
+			taskStatus, err := tasker.Status(context.Background(), id)
+			return err == nil && taskStatus.State == models.TaskStateDead
+		}, time.Second, 10*time.Millisecond)
 	}
 
-	// Try to add one more - should fail (queue full)
-	err = tasker.CreateDelayed(
-		context.Background(),
-		"localhost",
-		"test",
-		map[string]string{"task_id": "task_overflow"},
-		time.Now().UTC().Add(1*time.Second),
-	)
-	ts.Require().Error(err)
-	ts.Require().ErrorIs(err, ErrCreateDelayed)
+	_, err = tasker.Create(context.Background(), "retry_after", nil)
+	ts.Require().NoError(err)
+
+	ts.Require().Eventually(func() bool { return len(retryAfterCalls) == 2 }, 2*time.Second, 10*time.Millisecond)
+
+	first, second := <-retryAfterCalls, <-retryAfterCalls
+	ts.Require().GreaterOrEqual(second.Sub(first), 300*time.Millisecond)
+
+	ts.Require().Equal(int32(1), permanentCalls.Load())
+	ts.Require().Equal(int32(1), predicateCalls.Load())
+
+	ts.Require().True(IsPermanent(fmt.Errorf("wrapped: %w", Permanent(errValidation))))
+	ts.Require().ErrorIs(Permanent(errValidation), errValidation)
+	ts.Require().NoError(Permanent(nil))
 
 	tasker.Stop()
 }
 
+func (ts *TasksSuite) TestTasks_HandlerOptions() {
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithProvider(mocks.New(), "test"),
+		WithNumWorkers(1),
+		WithRetryPolicy(models.RetryPolicy{InitialInterval: time.Hour}),
+		WithStatusStore(status.NewMemoryStore()),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	release := make(chan struct{})
+
+	err = tasker.RegisterHandler("slow", func(map[string]string) error {
+		<-release
+		return nil
+	}, WithHandlerConcurrency(1, 5))
+	ts.Require().NoError(err)
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	fast := make(chan struct{}, 1)
+
+	// Handler registered after Start uses common workers.
+	err = tasker.RegisterHandler("fast", func(map[string]string) error {
+		fast <- struct{}{}
+		return nil
+	})
+	ts.Require().NoError(err)
+
+	deadlines := make(chan time.Duration, 1)
+
+	err = tasker.RegisterHandlerCtx("timeout", func(ctx context.Context, _ TaskInfo) error {
+		deadline, _ := ctx.Deadline()
+		deadlines <- time.Until(deadline)
+
+		return nil
+	}, WithHandlerTimeout(time.Second), WithHandlerConcurrency(1))
+	ts.Require().NoError(err)
+
+	var retryCalls atomic.Int32
+
+	err = tasker.RegisterHandler("retry", func(map[string]string) error {
+		retryCalls.Add(1)
+		return errors.New("failed")
+	}, WithHandlerRetry(models.RetryPolicy{InitialInterval: 10 * time.Millisecond, MaximumAttempts: 1}))
+	ts.Require().NoError(err)
+
+	ts.Run("Concurrency", func() {
+		for range 2 {
+			_, err = tasker.Create(context.Background(), "slow", nil)
+			ts.Require().NoError(err)
+		}
+
+		_, err = tasker.Create(context.Background(), "fast", nil)
+		ts.Require().NoError(err)
+
+		select {
+		case <-fast:
+		case <-time.After(time.Second):
+			ts.Fail("fast task is blocked by slow one")
+		}
+
+		close(release)
+	})
+
+	ts.Run("Timeout", func() {
+		_, err = tasker.Create(context.Background(), "timeout", nil)
+		ts.Require().NoError(err)
+
+		select {
+		case deadline := <-deadlines:
+			ts.Require().LessOrEqual(deadline, time.Second)
+			ts.Require().Greater(deadline, 900*time.Millisecond)
+		case <-time.After(time.Second):
+			ts.Fail("timeout task was not executed")
+		}
+	})
+
+	ts.Run("Retry", func() {
+		id, err := tasker.Create(context.Background(), "retry", nil)
+		ts.Require().NoError(err)
+
+		ts.Require().Eventually(func() bool {
+			taskStatus, err := tasker.Status(context.Background(), id)
+			return err == nil && taskStatus.State == models.TaskStateDead
+		}, time.Second, 10*time.Millisecond)
+
+		ts.Require().Equal(int32(2), retryCalls.Load())
+	})
+
+	tasker.Stop()
+}
+
+func (ts *TasksSuite) TestTasks_Backoff() {
+	policy := func(strategy models.BackoffStrategy, jitter models.Jitter) models.RetryPolicy {
+		return retryPolicyWithDefaults(models.RetryPolicy{
+			InitialInterval: time.Second,
+			MaximumInterval: 10 * time.Second,
+			Strategy:        strategy,
+			Jitter:          jitter,
+		})
+	}
+
+	ts.Run("Strategies", func() {
+		for _, tc := range []struct {
+			strategy models.BackoffStrategy
+			expected []time.Duration
+		}{
+			{strategy: "", expected: []time.Duration{1, 2, 4, 8, 10}},
+			{strategy: models.BackoffLinear, expected: []time.Duration{1, 2, 3, 4, 5}},
+			{strategy: models.BackoffFixed, expected: []time.Duration{1, 1, 1, 1, 1}},
+		} {
+			for i, expected := range tc.expected {
+				ts.Require().Equal(expected*time.Second, calculateBackoff(policy(tc.strategy, ""), i+1, 0, nil), tc.strategy)
+			}
+		}
+	})
+
+	ts.Run("Jitter", func() {
+		for range 100 {
+			backoff := calculateBackoff(policy(models.BackoffFixed, models.JitterFull), 3, 0, nil)
+			ts.Require().GreaterOrEqual(backoff, time.Duration(0))
+			ts.Require().LessOrEqual(backoff, time.Second)
+
+			backoff = calculateBackoff(policy(models.BackoffExponential, models.JitterEqual), 3, 0, nil)
+			ts.Require().GreaterOrEqual(backoff, 2*time.Second)
+			ts.Require().LessOrEqual(backoff, 4*time.Second)
+
+			backoff = calculateBackoff(policy("", models.JitterDecorrelated), 3, 2*time.Second, nil)
+			ts.Require().GreaterOrEqual(backoff, time.Second)
+			ts.Require().LessOrEqual(backoff, 6*time.Second)
+		}
+	})
+
+	ts.Run("BackoffFunc", func() {
+		errRateLimited := errors.New("rate limited")
+
+		retryPolicy := policy("", "")
+		retryPolicy.BackoffFunc = func(attempt int, err error) time.Duration {
+			if errors.Is(err, errRateLimited) {
+				return time.Minute
+			}
+
+			return time.Duration(attempt-2) * time.Millisecond
+		}
+
+		ts.Require().Equal(3*time.Millisecond, calculateBackoff(retryPolicy, 5, 0, errors.New("failed")))
+
+		// Result is limited to [0, MaximumInterval].
+		ts.Require().Equal(10*time.Second, calculateBackoff(retryPolicy, 1, 0, errRateLimited))
+		ts.Require().Zero(calculateBackoff(retryPolicy, 1, 0, errors.New("failed")))
+	})
+}
+
+func (ts *TasksSuite) TestTasks_CircuitBreaker() {
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithProvider(mocks.New(), "test"),
+		WithNumWorkers(1),
+		WithRetryPolicy(models.RetryPolicy{InitialInterval: 10 * time.Millisecond, Strategy: models.BackoffFixed}),
+		WithStatusStore(status.NewMemoryStore()),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	var (
+		down  atomic.Bool
+		calls atomic.Int32
+	)
+
+	down.Store(true)
+
+	changes := make(chan breaker.State, 10)
+
+	err = tasker.RegisterHandler("flaky", func(map[string]string) error {
+		calls.Add(1)
+		if down.Load() {
+			return errors.New("dependency is down")
+		}
+
+		return nil
+	}, WithCircuitBreaker(breaker.Config{
+		MinRequests: 2,
+		OpenTimeout: 300 * time.Millisecond,
+		OnStateChange: func(_ string, _, to breaker.State) {
+			changes <- to
+		},
+	}))
+	ts.Require().NoError(err)
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	id, err := tasker.Create(context.Background(), "flaky", nil)
+	ts.Require().NoError(err)
+
+	select {
+	case state := <-changes:
+		ts.Require().Equal(breaker.StateOpen, state)
+	case <-time.After(time.Second):
+		ts.FailNow("circuit breaker was not opened")
+	}
+
+	// Retries are deferred while breaker is open.
+	time.Sleep(200 * time.Millisecond)
+	ts.Require().Equal(int32(2), calls.Load())
+
+	down.Store(false)
+
+	ts.Require().Eventually(func() bool {
+		taskStatus, err := tasker.Status(context.Background(), id)
+		return err == nil && taskStatus.State == models.TaskStateSucceeded
+	}, 2*time.Second, 10*time.Millisecond)
+
+	ts.Require().Equal(breaker.StateHalfOpen, <-changes)
+	ts.Require().Equal(breaker.StateClosed, <-changes)
+
+	stats := tasker.CircuitBreakers()
+	ts.Require().Len(stats, 1)
+	ts.Require().Equal("flaky", stats[0].Name)
+	ts.Require().Equal(breaker.StateClosed, stats[0].State)
+	ts.Require().Positive(stats[0].Rejected)
+
+	tasker.Stop()
+
+	ts.Run("Task can't be deferred", func() {
+		circuit := tasker.(*Tasks).tasksHandlers["flaky"].breaker
+		for range 2 {
+			_, _, generation := circuit.Allow()
+			circuit.Done(generation, false)
+		}
+
+		// Delayed queue is closed after Stop, so task is dead-lettered instead of staying pending.
+		err := tasker.(*Tasks).processTask(context.Background(), models.Task{ID: id, Name: "flaky"})
+		ts.Require().ErrorIs(err, errProcessTask)
+		ts.Require().ErrorIs(err, ErrStopped)
+
+		taskStatus, err := tasker.Status(context.Background(), id)
+		ts.Require().NoError(err)
+		ts.Require().Equal(models.TaskStateDead, taskStatus.State)
+	})
+}
+
+func (ts *TasksSuite) TestTasks_CreateDelayed_CancelContext() {
+	mockProvider := mocks.New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	tasker, err := New(
+		WithContext(ctx),
+		WithProvider(mockProvider, "test"),
+		WithRetryPolicy(models.RetryPolicy{
+			BackoffCoefficient: 2.0,
+			MaximumAttempts:    3,
+		}),
+		WithNumWorkers(1),
+		WithQueueSize(1),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	err = tasker.RegisterHandler("test", testTask)
+	ts.Require().NoError(err)
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	// Fill the queue
+	_, err = tasker.CreateDelayed(
+		ctx,
+		"localhost",
+		"test",
+		map[string]string{"task_id": "task_1"},
+		time.Now().UTC().Add(1*time.Second),
+	)
+	ts.Require().NoError(err)
+
+	// Cancel context
+	cancel()
+
+	// Try to create another delayed task with canceled context
+	_, err = tasker.CreateDelayed(
+		ctx,
+		"localhost",
+		"test",
+		map[string]string{"task_id": "task_2"},
+		time.Now().UTC().Add(1*time.Second),
+	)
+	ts.Require().Error(err)
+
+	tasker.Stop()
+}
+
+func (ts *TasksSuite) TestTasks_CreateDelayed_QueueFull() {
+	mockProvider := mocks.New()
+
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithProvider(mockProvider, "test"),
+		WithRetryPolicy(models.RetryPolicy{
+			BackoffCoefficient: 2.0,
+			MaximumAttempts:    3,
+		}),
+		WithNumWorkers(1),
+		WithQueueSize(2), // Small queue size
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	err = tasker.RegisterHandler("test", func(params map[string]string) error {
+		time.Sleep(5 * time.Second) // Slow handler
+		return nil
+	})
+	ts.Require().NoError(err)
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	// Fill the queue
+	for i := 0; i < 2; i++ {
+		_, err = tasker.CreateDelayed(
+			context.Background(),
+			"localhost",
+			"test",
+			map[string]string{"task_id": fmt.Sprintf("task_%d", i)},
+			time.Now().UTC().Add(1*time.Second),
+		)
+		ts.Require().NoError(err)
+	}
+
+	// Try to add one more - should fail (queue full)
+	_, err = tasker.CreateDelayed(
+		context.Background(),
+		"localhost",
+		"test",
+		map[string]string{"task_id": "task_overflow"},
+		time.Now().UTC().Add(1*time.Second),
+	)
+	ts.Require().Error(err)
+	ts.Require().ErrorIs(err, ErrCreateDelayed)
+
+	tasker.Stop()
+}
+
+func (ts *TasksSuite) TestTasks_RegisterHandlerCtx() {
+	mockProvider := mocks.New()
+
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithProvider(mockProvider, "test"),
+		WithNumWorkers(1),
+		WithExecutionTimeout(time.Minute),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	started := make(chan TaskInfo, 1)
+	cancelled := make(chan error, 1)
+
+	err = tasker.RegisterHandlerCtx("ctx_test", func(ctx context.Context, task TaskInfo) error {
+		_, hasDeadline := ctx.Deadline()
+		ts.True(hasDeadline)
+
+		started <- task
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+
+		return nil
+	})
+	ts.Require().NoError(err)
+
+	err = tasker.RegisterHandlerCtx("ctx_test", func(context.Context, TaskInfo) error { return nil })
+	ts.Require().ErrorIs(err, ErrTaskNameAlreadyRegistered)
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	_, err = tasker.Create(context.Background(), "ctx_test", map[string]string{"data": "dummy"})
+	ts.Require().NoError(err)
+
+	task := <-started
+	ts.Require().Equal("ctx_test", task.Name)
+	ts.Require().Equal("dummy", task.Params["data"])
+
+	tasker.Stop()
+
+	ts.Require().ErrorIs(<-cancelled, context.Canceled)
+}
+
+func (ts *TasksSuite) TestTasks_TaskID() {
+	mockProvider := mocks.New()
+
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithProvider(mockProvider, "test"),
+		WithRetryPolicy(models.RetryPolicy{
+			InitialInterval: 100 * time.Millisecond,
+			MaximumAttempts: 3,
+		}),
+		WithNumWorkers(1),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	executed := make(chan string, 4)
+
+	var attempts atomic.Int32
+
+	err = tasker.RegisterHandlerCtx("id_test", func(_ context.Context, task TaskInfo) error {
+		executed <- task.ID
+		if attempts.Add(1) < 2 {
+			//nolint:err113
+			return errors.New("first attempt fails")
+		}
+
+		return nil
+	})
+	ts.Require().NoError(err)
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	id, err := tasker.Create(context.Background(), "id_test", nil)
+	ts.Require().NoError(err)
+	ts.Require().NotEmpty(id)
+
+	// Both first attempt and retry carry the same ID.
+	ts.Require().Equal(id, <-executed)
+	ts.Require().Equal(id, <-executed)
+
+	otherID, err := tasker.CreateDelayed(context.Background(), "localhost", "id_test", nil,
+		time.Now().UTC().Add(time.Hour))
+	ts.Require().NoError(err)
+	ts.Require().NotEqual(id, otherID)
+
+	tasker.Stop()
+}
+
+func (ts *TasksSuite) TestTasks_Status() {
+	mockProvider := mocks.New()
+
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithProvider(mockProvider, "test"),
+		WithRetryPolicy(models.RetryPolicy{
+			InitialInterval: 100 * time.Millisecond,
+			MaximumAttempts: 1,
+		}),
+		WithNumWorkers(1),
+		WithStatusStore(status.NewMemoryStore()),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	err = tasker.RegisterHandler("test", testTask)
+	ts.Require().NoError(err)
+
+	err = tasker.RegisterHandler("test_error", testTaskWithError)
+	ts.Require().NoError(err)
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	succeededID, err := tasker.Create(context.Background(), "test", nil)
+	ts.Require().NoError(err)
+
+	deadID, err := tasker.Create(context.Background(), "test_error", nil)
+	ts.Require().NoError(err)
+
+	delayedID, err := tasker.CreateDelayed(context.Background(), "localhost", "test", nil,
+		time.Now().UTC().Add(time.Hour))
+	ts.Require().NoError(err)
+
+	ts.Require().Eventually(func() bool {
+		taskStatus, err := tasker.Status(context.Background(), deadID)
+		return err == nil && taskStatus.State == models.TaskStateDead
+	}, 3*time.Second, 50*time.Millisecond)
+
+	taskStatus, err := tasker.Status(context.Background(), succeededID)
+	ts.Require().NoError(err)
+	ts.Require().Equal(models.TaskStateSucceeded, taskStatus.State)
+	ts.Require().Equal(1, taskStatus.Attempts)
+
+	taskStatus, err = tasker.Status(context.Background(), deadID)
+	ts.Require().NoError(err)
+	ts.Require().Equal(2, taskStatus.Attempts)
+	ts.Require().Equal("task error", taskStatus.LastError)
+
+	statuses, err := tasker.List(context.Background(), models.StatusFilter{
+		States: []models.TaskState{models.TaskStatePending},
+	})
+	ts.Require().NoError(err)
+	ts.Require().Len(statuses, 1)
+	ts.Require().Equal(delayedID, statuses[0].ID)
+
+	_, err = tasker.Status(context.Background(), "unknown")
+	ts.Require().ErrorIs(err, status.ErrNotFound)
+
+	tasker.Stop()
+}
+
+func (ts *TasksSuite) TestTasks_Cancel() {
+	mockProvider := mocks.New()
+
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithProvider(mockProvider, "test"),
+		WithRetryPolicy(models.RetryPolicy{
+			InitialInterval: time.Second,
+			MaximumAttempts: 3,
+		}),
+		WithNumWorkers(1),
+		WithStatusStore(status.NewMemoryStore()),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	var executions atomic.Int32
+
+	err = tasker.RegisterHandler("cancel_test", func(map[string]string) error {
+		executions.Add(1)
+		//nolint:err113
+		return errors.New("always fails")
+	})
+	ts.Require().NoError(err)
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	ts.Run("Delayed task", func() {
+		id, err := tasker.CreateDelayed(context.Background(), "localhost", "cancel_test", nil,
+			time.Now().UTC().Add(500*time.Millisecond))
+		ts.Require().NoError(err)
+
+		err = tasker.Cancel(context.Background(), id)
+		ts.Require().NoError(err)
+
+		time.Sleep(time.Second)
+		ts.Require().Zero(executions.Load())
+
+		taskStatus, err := tasker.Status(context.Background(), id)
+		ts.Require().NoError(err)
+		ts.Require().Equal(models.TaskStateCancelled, taskStatus.State)
+		ts.Require().Equal("cancel_test", taskStatus.Name)
+
+		err = tasker.Cancel(context.Background(), id)
+		ts.Require().ErrorIs(err, ErrTaskFinished)
+	})
+
+	ts.Run("Retrying task", func() {
+		id, err := tasker.Create(context.Background(), "cancel_test", nil)
+		ts.Require().NoError(err)
+
+		ts.Require().Eventually(func() bool {
+			taskStatus, err := tasker.Status(context.Background(), id)
+			return err == nil && taskStatus.State == models.TaskStateRetrying
+		}, time.Second, 10*time.Millisecond)
+
+		err = tasker.Cancel(context.Background(), id)
+		ts.Require().NoError(err)
+
+		time.Sleep(1500 * time.Millisecond)
+		ts.Require().Equal(int32(1), executions.Load())
+	})
+
+	ts.Run("Unknown task", func() {
+		err := tasker.Cancel(context.Background(), "unknown")
+		ts.Require().ErrorIs(err, status.ErrNotFound)
+
+		_, err = tasker.Status(context.Background(), "unknown")
+		ts.Require().ErrorIs(err, status.ErrNotFound)
+	})
+
+	tasker.Stop()
+}
+
+func (ts *TasksSuite) TestTasks_CancelSharedStatusStore() {
+	store := status.NewMemoryStore()
+
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithProvider(mocks.New(), "test"),
+		WithNumWorkers(1),
+		WithStatusStore(store),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	var executions atomic.Int32
+
+	err = tasker.RegisterHandler("cancel_shared_test", func(map[string]string) error {
+		executions.Add(1)
+		return nil
+	})
+	ts.Require().NoError(err)
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	defer tasker.Stop()
+
+	id, err := tasker.CreateDelayed(context.Background(), "localhost", "cancel_shared_test", nil,
+		time.Now().UTC().Add(300*time.Millisecond))
+	ts.Require().NoError(err)
+
+	// Task is cancelled by another instance, tombstone isn't received by this one.
+	err = store.Update(context.Background(), id, func(taskStatus *models.TaskStatus) {
+		taskStatus.State = models.TaskStateCancelled
+	})
+	ts.Require().NoError(err)
+
+	time.Sleep(time.Second)
+	ts.Require().Zero(executions.Load())
+}
+
+func (ts *TasksSuite) TestTasks_CancelQueueTopic() {
+	for _, tc := range []struct {
+		store  status.Store
+		name   string
+		topics []string
+	}{
+		{name: "Queue is known by status", store: status.NewMemoryStore(), topics: []string{"test-bulk"}},
+		{name: "Queue is unknown", topics: []string{"test", "test-bulk"}},
+	} {
+		ts.Run(tc.name, func() {
+			memoryBroker := &publishRecorder{Broker: broker.NewMemory(), published: make(chan *broker.Message, 10)}
+			defer memoryBroker.Close()
+
+			opts := []Option{
+				WithContext(context.Background()),
+				WithBroker(memoryBroker, "test"),
+				WithQueue("bulk", QueueConfig{Topic: "test-bulk", Tasks: []string{"bulk"}}),
+				WithLogger(logger.DefaultLogger{}),
+			}
+			if tc.store != nil {
+				opts = append(opts, WithStatusStore(tc.store))
+			}
+
+			tasker, err := New(opts...)
+			ts.Require().NoError(err)
+
+			id, err := tasker.Create(context.Background(), "bulk", nil)
+			ts.Require().NoError(err)
+			ts.Require().Equal("test-bulk", (<-memoryBroker.published).Topic)
+
+			err = tasker.Cancel(context.Background(), id)
+			ts.Require().NoError(err)
+
+			topics := make([]string, 0, len(tc.topics))
+			for range tc.topics {
+				topics = append(topics, (<-memoryBroker.published).Topic)
+			}
+
+			ts.Require().ElementsMatch(tc.topics, topics)
+			ts.Require().Empty(memoryBroker.published)
+		})
+	}
+}
+
+func (ts *TasksSuite) TestTasks_ScheduledLease() {
+	backend := lease.NewMemory()
+
+	var executions atomic.Int32
+
+	taskers := make([]Tasker, 0, 2)
+
+	for range 2 {
+		tasker, err := New(
+			WithContext(context.Background()),
+			WithProvider(mocks.New(), "test"),
+			WithNumWorkers(1),
+			WithLease(backend, time.Minute),
+			WithLogger(logger.DefaultLogger{}),
+		)
+		ts.Require().NoError(err)
+
+		err = tasker.RegisterHandler("lease_test", func(map[string]string) error {
+			executions.Add(1)
+			return nil
+		})
+		ts.Require().NoError(err)
+
+		err = tasker.Start()
+		ts.Require().NoError(err)
+
+		_, err = tasker.CreateScheduled(context.Background(), "lease_test", nil,
+			time.Now().UTC().Add(-time.Hour), time.Hour)
+		ts.Require().NoError(err)
+
+		taskers = append(taskers, tasker)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	// Both instances have the schedule, but only the lease owner created the task.
+	ts.Require().Equal(int32(1), executions.Load())
+
+	for _, tasker := range taskers {
+		tasker.Stop()
+	}
+}
+
+func (ts *TasksSuite) TestTasks_ScheduledLeaseFailover() {
+	memoryBroker := &publishRecorder{Broker: broker.NewMemory(), published: make(chan *broker.Message, 10)}
+	defer memoryBroker.Close()
+
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithBroker(memoryBroker, "test"),
+		WithLease(lease.NewMemory(), time.Minute),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	t := tasker.(*Tasks)
+
+	// Occurrences are 90 and 30 seconds ago and 30 seconds later.
+	start := time.Now().UTC().Add(-150 * time.Second)
+
+	_, err = tasker.CreateScheduled(context.Background(), "failover_test", nil, start, time.Minute)
+	ts.Require().NoError(err)
+
+	scheduleQueue := &RetryQueue{}
+
+	// Follower drops only occurrences older than lease TTL, the leader could still create the others.
+	t.loadScheduleQueue(scheduleQueue, false)
+	t.processScheduledTasks(context.Background(), scheduleQueue, false)
+	ts.Require().Empty(memoryBroker.published)
+
+	// Leader died, the follower took over and creates occurrence which the old leader didn't.
+	t.loadScheduleQueue(scheduleQueue, true)
+	t.processScheduledTasks(context.Background(), scheduleQueue, true)
+
+	var task models.Task
+
+	ts.Require().Len(memoryBroker.published, 1)
+	ts.Require().NoError(json.Unmarshal((<-memoryBroker.published).Body, &task))
+	ts.Require().Equal(start.Add(2*time.Minute), task.FireTime)
+
+	ts.Run("Schedules are not locked while publishing", func() {
+		// The first occurrence is due now.
+		_, err := tasker.CreateScheduled(context.Background(), "locked_test", nil,
+			time.Now().UTC().Add(-time.Hour), time.Hour)
+		ts.Require().NoError(err)
+
+		// Occurrence is reported when publishing starts and is blocked till the gate is opened.
+		gated := &gatedBroker{Broker: memoryBroker, gate: make(chan error)}
+		recorder := &publishRecorder{Broker: gated, published: make(chan *broker.Message, 1)}
+		t.broker = recorder
+
+		scheduleQueue := &RetryQueue{}
+		t.loadScheduleQueue(scheduleQueue, true)
+
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+			t.processScheduledTasks(context.Background(), scheduleQueue, true)
+		}()
+
+		<-recorder.published
+
+		paused := make(chan error, 1)
+
+		go func() {
+			paused <- tasker.PauseScheduled(context.Background(), "locked_test")
+		}()
+
+		select {
+		case err := <-paused:
+			ts.Require().NoError(err)
+		case <-time.After(time.Second):
+			ts.FailNow("schedule is locked while occurrence is published")
+		}
+
+		gated.gate <- nil
+		<-done
+	})
+}
+
+type checkStatusPayload struct {
+	Name  string `json:"name"`
+	ID    int    `json:"id"`
+	Force bool   `json:"force"`
+}
+
+func (ts *TasksSuite) TestTasks_Define() {
+	mockProvider := mocks.New()
+
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithProvider(mockProvider, "test"),
+		WithNumWorkers(1),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	received := make(chan checkStatusPayload, 2)
+
+	checkStatus, err := Define(tasker, "check_status", func(_ context.Context, payload checkStatusPayload) error {
+		received <- payload
+		return nil
+	})
+	ts.Require().NoError(err)
+	ts.Require().Equal("check_status", checkStatus.Name())
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	ts.Run("Typed payload", func() {
+		_, err = checkStatus.Enqueue(context.Background(), checkStatusPayload{Name: "typed", ID: 42, Force: true})
+		ts.Require().NoError(err)
+
+		ts.Require().Equal(checkStatusPayload{Name: "typed", ID: 42, Force: true}, <-received)
+	})
+
+	ts.Run("Params only message", func() {
+		_, err = tasker.Create(context.Background(), "check_status", map[string]string{
+			"name": "legacy", "id": "7", "force": "true",
+		})
+		ts.Require().NoError(err)
+
+		ts.Require().Equal(checkStatusPayload{Name: "legacy", ID: 7, Force: true}, <-received)
+	})
+
+	tasker.Stop()
+}
+
+func (ts *TasksSuite) TestTasks_Meta() {
+	mockProvider := mocks.New()
+
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithProvider(mockProvider, "test"),
+		WithNumWorkers(1),
+		WithRetryPolicy(models.RetryPolicy{
+			InitialInterval:    10 * time.Millisecond,
+			BackoffCoefficient: 1.0,
+			MaximumAttempts:    1,
+		}),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	type received struct {
+		info        TaskInfo
+		traceParent string
+	}
+
+	handled := make(chan received, 4)
+
+	err = tasker.RegisterHandlerCtx("meta", func(ctx context.Context, info TaskInfo) error {
+		handled <- received{info: info, traceParent: TraceParentFromContext(ctx)}
+
+		if info.Params["fail"] == "true" && info.Meta().Attempt == 1 {
+			return errors.New("first attempt fails")
+		}
+
+		return nil
+	})
+	ts.Require().NoError(err)
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	next := func() received {
+		select {
+		case r := <-handled:
+			return r
+		case <-time.After(2 * time.Second):
+			ts.FailNow("task was not handled")
+		}
+
+		return received{}
+	}
+
+	ts.Run("Envelope", func() {
+		traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+		ctx := ContextWithTraceParent(context.Background(), traceParent)
+
+		_, err = tasker.Create(ctx, "meta", map[string]string{"attempts": "5", "fail": "true"})
+		ts.Require().NoError(err)
+
+		first := next()
+		ts.Require().Equal(1, first.info.Meta().Attempt)
+		ts.Require().Equal(models.OriginCreate, first.info.Meta().Origin)
+		ts.Require().Equal(traceParent, first.info.Meta().TraceParent)
+		ts.Require().Equal(traceParent, first.traceParent)
+
+		// User param with the same name as legacy metadata doesn't affect retries.
+		retry := next()
+		ts.Require().Equal(2, retry.info.Meta().Attempt)
+		ts.Require().Equal(models.OriginRetry, retry.info.Meta().Origin)
+		ts.Require().Equal("first attempt fails", retry.info.Meta().LastError)
+		ts.Require().Equal(first.info.Meta().FirstEnqueuedAt, retry.info.Meta().FirstEnqueuedAt)
+		ts.Require().Equal("5", retry.info.Params["attempts"])
+	})
+
+	ts.Run("Legacy message", func() {
+		task := models.Task{
+			ID:     "legacy",
+			Name:   "meta",
+			Params: map[string]string{"attempts": "1", "delayed": "true", "key": "value"},
+		}
+
+		err = tasker.(*Tasks).publishTo(context.Background(), "test", task, task)
+		ts.Require().NoError(err)
+
+		legacy := next()
+		ts.Require().Equal(2, legacy.info.Meta().Attempt)
+		ts.Require().Equal(models.OriginRetry, legacy.info.Meta().Origin)
+		ts.Require().Equal(map[string]string{"key": "value"}, legacy.info.Params)
+	})
+
+	tasker.Stop()
+}
+
+func (ts *TasksSuite) TestTasks_MemoryBroker() {
+	memoryBroker := broker.NewMemory(broker.WithRedeliveryDelay(50 * time.Millisecond))
+	defer memoryBroker.Close()
+
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithBroker(memoryBroker, "test"),
+		WithNumWorkers(2),
+		WithDurableDelays(100*time.Millisecond),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	executed := make(chan string, 10)
+
+	err = tasker.RegisterHandler("memory_broker", func(params map[string]string) error {
+		executed <- params["id"]
+		return nil
+	})
+	ts.Require().NoError(err)
+
+	// Task created before start is delivered on subscription.
+	_, err = tasker.Create(context.Background(), "memory_broker", map[string]string{"id": "early"})
+	ts.Require().NoError(err)
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	_, err = tasker.CreateDelayed(context.Background(), "localhost", "memory_broker", map[string]string{"id": "delayed"},
+		time.Now().UTC().Add(200*time.Millisecond))
+	ts.Require().NoError(err)
+
+	received := make([]string, 0, 2)
+
+	for range 2 {
+		select {
+		case id := <-executed:
+			received = append(received, id)
+		case <-time.After(2 * time.Second):
+			ts.FailNow("task was not executed")
+		}
+	}
+
+	ts.Require().ElementsMatch([]string{"early", "delayed"}, received)
+
+	tasker.Stop()
+}
+
+func (ts *TasksSuite) TestTasks_AckAfterProcessing() {
+	memoryBroker := &ackRecorder{Broker: broker.NewMemory(), acked: make(chan string, 10)}
+	defer memoryBroker.Close()
+
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithBroker(memoryBroker, "test"),
+		WithNumWorkers(1),
+		WithAckAfterProcessing(),
+		WithDurableDelays(),
+		WithDurableRetries(),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	err = tasker.RegisterHandler("ack_after_processing", func(map[string]string) error {
+		close(started)
+		<-release
+
+		return nil
+	})
+	ts.Require().NoError(err)
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	_, err = tasker.Create(context.Background(), "ack_after_processing", nil)
+	ts.Require().NoError(err)
+
+	<-started
+
+	select {
+	case <-memoryBroker.acked:
+		ts.FailNow("message was acked before task was processed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case topic := <-memoryBroker.acked:
+		ts.Require().Equal("test", topic)
+	case <-time.After(time.Second):
+		ts.FailNow("message was not acked after task was processed")
+	}
+
+	tasker.Stop()
+
+	ts.Run("Short ack timeout is logged", func() {
+		recorder := &levelRecorder{
+			template: "ack timeout of broker is shorter than execution timeout: %s",
+			levels:   make(chan string, 1),
+		}
+
+		_, err := New(
+			WithContext(context.Background()),
+			WithBroker(broker.NewMemory(), "test"),
+			WithAckAfterProcessing(),
+			WithDurableDelays(),
+			WithDurableRetries(),
+			WithLogger(recorder),
+		)
+		ts.Require().NoError(err)
+		ts.Require().Equal(logger.LogLevelError, <-recorder.levels)
+	})
+}
+
+func (ts *TasksSuite) TestTasks_StopReleasesPendingMessages() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tasker, err := New(
+		WithContext(ctx),
+		WithProvider(mocks.New(), "test"),
+		WithNumWorkers(1),
+		WithAckAfterProcessing(),
+		WithDurableDelays(),
+		WithDurableRetries(),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	started := make(chan struct{}, 2)
+
+	err = tasker.RegisterHandlerCtx("pending", func(ctx context.Context, _ TaskInfo) error {
+		started <- struct{}{}
+		<-ctx.Done()
+
+		return nil
+	})
+	ts.Require().NoError(err)
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	// Provider handler waits for ack of the message, so Create returns when message is acked or released.
+	created := make(chan error, 2)
+	create := func() {
+		_, err := tasker.Create(context.Background(), "pending", nil)
+		created <- err
+	}
+
+	go create()
+
+	<-started
+
+	go create()
+
+	ts.Require().Eventually(func() bool {
+		return tasker.(*Tasks).queuedTasks() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Workers exit on cancelled context, so queued task isn't processed.
+	cancel()
+	tasker.Stop()
+
+	for range 2 {
+		select {
+		case <-created:
+		case <-time.After(3 * time.Second):
+			ts.FailNow("provider handler was not released on Stop")
+		}
+	}
+}
+
+func (ts *TasksSuite) TestTasks_AckAfterProcessingRequiresDurableQueues() {
+	_, err := New(
+		WithContext(context.Background()),
+		WithBroker(broker.NewMemory(), "test"),
+		WithAckAfterProcessing(),
+		WithDurableRetries(),
+	)
+	ts.Require().ErrorIs(err, ErrAckWithoutDurableQueues)
+}
+
+func (ts *TasksSuite) TestTasks_EnqueueAfterStop() {
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithBroker(broker.NewMemory(), "test"),
+		WithNumWorkers(1),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	err = tasker.RegisterHandler("late", testTask)
+	ts.Require().NoError(err)
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	tasker.Stop()
+
+	// Message handled after the check of active consumers doesn't reach closed queues.
+	ts.Require().NotPanics(func() {
+		ts.Require().False(tasker.(*Tasks).enqueue(queuedTask{task: models.Task{ID: "1", Name: "late"}}))
+	})
+
+	ts.Run("Postponed task", func() {
+		ts.Require().NotPanics(func() {
+			err := tasker.(*Tasks).postpone(models.Task{ID: "2", Name: "late", StartTime: time.Now().Add(time.Hour)})
+			ts.Require().ErrorIs(err, errRedeliver)
+		})
+	})
+
+	ts.Run("Delayed task", func() {
+		_, err := tasker.CreateDelayed(context.Background(), "", "late", nil, time.Now().Add(time.Hour))
+		ts.Require().ErrorIs(err, ErrCreateDelayed)
+	})
+}
+
+// ackRecorder reports topics of acked messages.
+type ackRecorder struct {
+	broker.Broker
+	acked chan string
+}
+
+func (a *ackRecorder) Ack(ctx context.Context, msg *broker.Message) error {
+	a.acked <- msg.Topic
+
+	return a.Broker.Ack(ctx, msg)
+}
+
+func (ts *TasksSuite) TestTasks_Key() {
+	memoryBroker := broker.NewMemory()
+	defer memoryBroker.Close()
+
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithBroker(memoryBroker, "test"),
+		WithNumWorkers(4),
+		WithRetryPolicy(models.RetryPolicy{
+			InitialInterval:    100 * time.Millisecond,
+			BackoffCoefficient: 1.0,
+			MaximumAttempts:    3,
+		}),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	var (
+		executed []string
+		running  atomic.Int32
+		mu       sync.Mutex
+	)
+
+	done := make(chan struct{})
+
+	err = tasker.RegisterHandlerCtx("keyed", func(_ context.Context, task TaskInfo) error {
+		ts.Require().Equal(int32(1), running.Add(1), "tasks of the key run concurrently")
+		defer running.Add(-1)
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		executed = append(executed, fmt.Sprintf("%s/%d", task.Params["n"], task.Meta().Attempt))
+		if len(executed) == 5 {
+			close(done)
+		}
+
+		if task.Params["n"] == "1" && task.Meta().Attempt < 3 {
+			return errors.New("first task fails twice")
+		}
+
+		return nil
+	})
+	ts.Require().NoError(err)
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	for _, n := range []string{"1", "2", "3"} {
+		_, err = tasker.Create(context.Background(), "keyed", map[string]string{"n": n}, WithKey("customer-1"))
+		ts.Require().NoError(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		ts.FailNow("keyed tasks were not executed")
+	}
+
+	mu.Lock()
+	ts.Require().Equal([]string{"1/1", "1/2", "1/3", "2/1", "3/1"}, executed)
+	mu.Unlock()
+
+	tasker.Stop()
+
+	ts.Run("Key is ignored by broker", func() {
+		recorder := &levelRecorder{template: "partition key is ignored by broker: %s", levels: make(chan string, 1)}
+
+		tasker, err := New(
+			WithContext(context.Background()),
+			WithProvider(mocks.New(), "test"),
+			WithLogger(recorder),
+		)
+		ts.Require().NoError(err)
+
+		err = tasker.RegisterHandler("keyed", testTask)
+		ts.Require().NoError(err)
+
+		err = tasker.Start()
+		ts.Require().NoError(err)
+
+		defer tasker.Stop()
+
+		_, err = tasker.Create(context.Background(), "keyed", nil, WithKey("customer-1"))
+		ts.Require().NoError(err)
+		ts.Require().Equal(logger.LogLevelError, <-recorder.levels)
+	})
+}
+
+func (ts *TasksSuite) TestTasks_KeyBlock() {
+	newTasker := func(opts ...Option) (*Tasks, chan string) {
+		tasker, err := New(append([]Option{
+			WithContext(context.Background()),
+			WithNumWorkers(1),
+			WithLogger(logger.DefaultLogger{}),
+		}, opts...)...)
+		ts.Require().NoError(err)
+
+		executed := make(chan string, 10)
+
+		err = tasker.RegisterHandlerCtx("keyed", func(_ context.Context, task TaskInfo) error {
+			executed <- task.ID
+			return nil
+		})
+		ts.Require().NoError(err)
+
+		err = tasker.Start()
+		ts.Require().NoError(err)
+
+		return tasker.(*Tasks), executed
+	}
+
+	// postponed returns task which blocks the key till deadline and never comes back.
+	postponed := func(deadline time.Duration) models.Task {
+		return models.Task{ID: "postponed", Key: "customer-1", StartTime: time.Now().Add(deadline - keyBlockMargin)}
+	}
+
+	ts.Run("Block expires", func() {
+		tasker, executed := newTasker(WithBroker(broker.NewMemory(), "test"))
+		defer tasker.Stop()
+
+		tasker.blockKey(postponed(300 * time.Millisecond))
+
+		id, err := tasker.Create(context.Background(), "keyed", nil, WithKey("customer-1"))
+		ts.Require().NoError(err)
+
+		ts.Require().Eventually(func() bool {
+			return tasker.queuedTasks() == 1
+		}, time.Second, 10*time.Millisecond, "parked task is not counted")
+
+		select {
+		case executedID := <-executed:
+			ts.Require().Equal(id, executedID)
+		case <-time.After(2 * time.Second):
+			ts.FailNow("parked task was not resumed after block expired")
+		}
+	})
+
+	ts.Run("Parked tasks are processed on Stop", func() {
+		tasker, executed := newTasker(WithBroker(broker.NewMemory(), "test"))
+
+		tasker.blockKey(postponed(time.Hour))
+
+		id, err := tasker.Create(context.Background(), "keyed", nil, WithKey("customer-1"))
+		ts.Require().NoError(err)
+
+		ts.Require().Eventually(func() bool {
+			return tasker.parkedTasks() == 1
+		}, time.Second, 10*time.Millisecond)
+
+		tasker.Stop()
+
+		ts.Require().Len(executed, 1)
+		ts.Require().Equal(id, <-executed)
+	})
+
+	ts.Run("Provider doesn't keep key order", func() {
+		tasker, executed := newTasker(WithProvider(mocks.New(), "test"))
+		defer tasker.Stop()
+
+		tasker.blockKey(postponed(time.Hour))
+
+		_, err := tasker.Create(context.Background(), "keyed", nil, WithKey("customer-1"))
+		ts.Require().NoError(err)
+
+		select {
+		case <-executed:
+		case <-time.After(time.Second):
+			ts.FailNow("task was parked by broker without key order")
+		}
+	})
+}
+
+func (ts *TasksSuite) TestTasks_Headers() {
+	memoryBroker := &publishRecorder{Broker: broker.NewMemory(), published: make(chan *broker.Message, 10)}
+	defer memoryBroker.Close()
+
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithBroker(memoryBroker, "test"),
+		WithNumWorkers(1),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	err = tasker.RegisterHandler("headers", testTask)
+	ts.Require().NoError(err)
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	ts.Run("Published headers", func() {
+		traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+		id, err := tasker.Create(ContextWithTraceParent(context.Background(), traceParent), "headers", nil)
+		ts.Require().NoError(err)
+
+		msg := <-memoryBroker.published
+		ts.Require().Equal(map[string]string{
+			HeaderTaskName:    "headers",
+			HeaderTaskID:      id,
+			HeaderTaskAttempt: "1",
+			HeaderTaskVersion: "2",
+			HeaderTraceParent: traceParent,
+		}, msg.Headers)
+	})
+
+	handleTask := tasker.(*Tasks).handleTask
+	garbage := []byte("not a task")
+
+	ts.Run("Unknown task is skipped without decoding", func() {
+		err = handleTask(context.Background(), &broker.Message{
+			Headers: map[string]string{HeaderTaskName: "unknown", HeaderTaskID: "1"},
+			Body:    garbage,
+		})
+		ts.Require().NoError(err)
+
+		err = handleTask(context.Background(), &broker.Message{
+			Headers: map[string]string{HeaderTaskName: "headers", HeaderTaskID: "1"},
+			Body:    garbage,
+		})
+		ts.Require().Error(err)
+	})
+
+	ts.Run("Unknown task is logged", func() {
+		for _, tc := range []struct {
+			level string
+			opts  []Option
+		}{
+			{level: logger.LogLevelError},
+			{level: logger.LogLevelDebug, opts: []Option{WithSkipUnknownTasks()}},
+		} {
+			recorder := &levelRecorder{template: "skipping task without handler: %s", levels: make(chan string, 1)}
+
+			tasker, err := New(append(tc.opts,
+				WithContext(context.Background()),
+				WithBroker(broker.NewMemory(), "test"),
+				WithLogger(recorder),
+			)...)
+			ts.Require().NoError(err)
+
+			skipped := tasker.(*Tasks).skipMessage(&broker.Message{
+				Headers: map[string]string{HeaderTaskName: "unknown", HeaderTaskID: "1"},
+			})
+			ts.Require().True(skipped)
+			ts.Require().Equal(tc.level, <-recorder.levels)
+		}
+	})
+
+	ts.Run("Cancelled task is skipped without decoding", func() {
+		err = tasker.Cancel(context.Background(), "cancelled")
+		ts.Require().NoError(err)
+
+		err = handleTask(context.Background(), &broker.Message{
+			Headers: map[string]string{HeaderTaskName: "headers", HeaderTaskID: "cancelled"},
+			Body:    garbage,
+		})
+		ts.Require().NoError(err)
+	})
+
+	tasker.Stop()
+}
+
+func (ts *TasksSuite) TestTasks_Queues() {
+	memoryBroker := &publishRecorder{Broker: broker.NewMemory(), published: make(chan *broker.Message, 10)}
+	defer memoryBroker.Close()
+
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithBroker(memoryBroker, "test"),
+		WithNumWorkers(1),
+		WithQueue("bulk", QueueConfig{Topic: "test-bulk", Workers: 1}),
+		WithQueue("fast", QueueConfig{Topic: "test-fast", Workers: 1, Tasks: []string{"remote"}}),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	release := make(chan struct{})
+	done := make(chan struct{})
+
+	err = tasker.RegisterHandler("bulk", func(map[string]string) error {
+		<-release
+		return nil
+	}, OnQueue("bulk"))
+	ts.Require().NoError(err)
+
+	err = tasker.RegisterHandler("fast", func(map[string]string) error {
+		close(done)
+		return nil
+	}, OnQueue("fast"))
+	ts.Require().NoError(err)
+
+	ts.Run("Unknown queue", func() {
+		err := tasker.RegisterHandler("unknown", testTask, OnQueue("unknown"))
+		ts.Require().ErrorIs(err, ErrUnknownQueue)
+	})
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	ts.Run("Slow queue doesn't block other queue", func() {
+		for i := 0; i < 3; i++ {
+			_, err := tasker.Create(context.Background(), "bulk", nil)
+			ts.Require().NoError(err)
+			ts.Require().Equal("test-bulk", (<-memoryBroker.published).Topic)
+		}
+
+		_, err := tasker.Create(context.Background(), "fast", nil)
+		ts.Require().NoError(err)
+		ts.Require().Equal("test-fast", (<-memoryBroker.published).Topic)
+
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			ts.FailNow("task of the fast queue was not executed")
+		}
+
+		close(release)
+	})
+
+	ts.Run("Task without handler is routed by queue config", func() {
+		_, err := tasker.Create(context.Background(), "remote", nil)
+		ts.Require().NoError(err)
+		ts.Require().Equal("test-fast", (<-memoryBroker.published).Topic)
+
+		_, err = tasker.Create(context.Background(), "other", nil)
+		ts.Require().NoError(err)
+		ts.Require().Equal("test", (<-memoryBroker.published).Topic)
+	})
+
+	tasker.Stop()
+}
+
+func (ts *TasksSuite) TestTasks_InvalidQueue() {
+	_, err := New(
+		WithContext(context.Background()),
+		WithBroker(broker.NewMemory(), "test"),
+		WithQueue("main", QueueConfig{Topic: "test"}),
+	)
+	ts.Require().ErrorIs(err, ErrInvalidQueue)
+}
+
+func (ts *TasksSuite) TestTasks_CreateBatch() {
+	memoryBroker := &batchRecorder{Broker: broker.NewMemory(), fail: "fail"}
+	defer memoryBroker.Close()
+
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithBroker(memoryBroker, "test"),
+		WithNumWorkers(2),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	var executed atomic.Int32
+
+	for _, name := range []string{"batch", "fail"} {
+		err = tasker.RegisterHandler(name, func(map[string]string) error {
+			executed.Add(1)
+			return nil
+		})
+		ts.Require().NoError(err)
+	}
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	results := tasker.CreateBatch(context.Background(), []TaskSpec{
+		{Name: "batch", Params: map[string]string{"n": "1"}},
+		{Name: "fail"},
+		{Name: "batch", Options: []CreateOption{WithKey("key")}},
+	})
+	ts.Require().Len(results, 3)
+
+	ts.Require().NoError(results[0].Err)
+	ts.Require().NotEmpty(results[0].ID)
+	ts.Require().ErrorIs(results[1].Err, ErrCreateBatch)
+	ts.Require().Empty(results[1].ID)
+	ts.Require().NoError(results[2].Err)
+	ts.Require().NotEqual(results[0].ID, results[2].ID)
+
+	ts.Require().Equal(1, memoryBroker.batches)
+
+	ts.Require().Eventually(func() bool {
+		return executed.Load() == 2
+	}, 3*time.Second, 10*time.Millisecond)
+
+	tasker.Stop()
+}
+
+func (ts *TasksSuite) TestTasks_CreateAsync() {
+	memoryBroker := &gatedBroker{Broker: broker.NewMemory(), gate: make(chan error)}
+	defer memoryBroker.Close()
+
+	tasker, err := New(
+		WithContext(context.Background()),
+		WithBroker(memoryBroker, "test"),
+		WithNumWorkers(1),
+		WithMaxInFlight(1),
+		WithLogger(logger.DefaultLogger{}),
+	)
+	ts.Require().NoError(err)
+
+	executed := make(chan string, 1)
+
+	err = tasker.RegisterHandlerCtx("async", func(_ context.Context, task TaskInfo) error {
+		executed <- task.ID
+		return nil
+	})
+	ts.Require().NoError(err)
+
+	err = tasker.Start()
+	ts.Require().NoError(err)
+
+	future, err := tasker.CreateAsync(context.Background(), "async", nil)
+	ts.Require().NoError(err)
+	ts.Require().NotEmpty(future.ID())
+
+	ts.Run("Limit of tasks in flight", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err := tasker.CreateAsync(ctx, "async", nil)
+		ts.Require().ErrorIs(err, ErrCreateAsync)
+		ts.Require().ErrorIs(err, context.DeadlineExceeded)
+	})
+
+	ts.Run("Delivered task", func() {
+		memoryBroker.gate <- nil
+
+		ts.Require().NoError(future.Wait(context.Background()))
+		ts.Require().Equal(future.ID(), <-executed)
+	})
+
+	ts.Run("Delivery error", func() {
+		future, err := tasker.CreateAsync(context.Background(), "async", nil)
+		ts.Require().NoError(err)
+
+		memoryBroker.gate <- errors.New("publish failed")
+
+		<-future.Done()
+		ts.Require().ErrorIs(future.Wait(context.Background()), ErrCreateAsync)
+	})
+
+	ts.Run("Delivery callback", func() {
+		var deliveredID string
+
+		delivered := make(chan error, 1)
+
+		future, err := tasker.CreateAsync(context.Background(), "async", nil,
+			WithCallback(func(id string, err error) {
+				deliveredID = id
+				delivered <- err
+			}))
+		ts.Require().NoError(err)
+
+		memoryBroker.gate <- errors.New("publish failed")
+		ts.Require().ErrorIs(<-delivered, ErrCreateAsync)
+		ts.Require().Equal(future.ID(), deliveredID)
+	})
+
+	tasker.Stop()
+
+	ts.Run("Create after Stop", func() {
+		_, err := tasker.CreateAsync(context.Background(), "async", nil)
+		ts.Require().ErrorIs(err, ErrStopped)
+	})
+
+	ts.Run("Invalid limit", func() {
+		_, err := New(WithContext(context.Background()), WithBroker(broker.NewMemory(), "test"), WithMaxInFlight(-1))
+		ts.Require().NoError(err)
+	})
+}
+
+// gatedBroker publishes message or returns error when gate gets nil or error.
+type gatedBroker struct {
+	broker.Broker
+	gate chan error
+}
+
+func (g *gatedBroker) Publish(ctx context.Context, msg *broker.Message) error {
+	err := <-g.gate
+	if err != nil {
+		return err
+	}
+
+	return g.Broker.Publish(ctx, msg)
+}
+
+// batchRecorder counts published batches and fails messages of the task name.
+type batchRecorder struct {
+	broker.Broker
+	fail    string
+	batches int
+}
+
+func (b *batchRecorder) PublishBatch(ctx context.Context, msgs []*broker.Message) []error {
+	b.batches++
+
+	errs := make([]error, len(msgs))
+
+	for i, msg := range msgs {
+		if msg.Headers[HeaderTaskName] == b.fail {
+			errs[i] = errors.New("publish failed")
+			continue
+		}
+
+		errs[i] = b.Publish(ctx, msg)
+	}
+
+	return errs
+}
+
+// publishRecorder reports published messages.
+type publishRecorder struct {
+	broker.Broker
+	published chan *broker.Message
+}
+
+func (p *publishRecorder) Publish(ctx context.Context, msg *broker.Message) error {
+	p.published <- msg
+
+	return p.Broker.Publish(ctx, msg)
+}
+
+// levelRecorder records levels of the messages logged by template.
+type levelRecorder struct {
+	logger.DefaultLogger
+	template string
+	levels   chan string
+}
+
+func (l *levelRecorder) Logf(level, template string, _ map[string]interface{}, _ ...interface{}) {
+	if template == l.template {
+		l.levels <- level
+	}
+}
+
 func testTask(params map[string]string) error {
 	log.Println("task params", params)
 	return nil
@@ -0,0 +1,33 @@
+package tasks
+
+import (
+	"context"
+	"time"
+
+	"gitlab.local.iti.domain/mc2/golibs/tasks/models"
+)
+
+type traceParentKey struct{}
+
+// ContextWithTraceParent returns context carrying W3C trace context. Tasks created with the context
+// keep it in metadata and handlers receive it in their context.
+func ContextWithTraceParent(ctx context.Context, traceParent string) context.Context {
+	return context.WithValue(ctx, traceParentKey{}, traceParent)
+}
+
+// TraceParentFromContext returns W3C trace context set by ContextWithTraceParent.
+func TraceParentFromContext(ctx context.Context) string {
+	traceParent, _ := ctx.Value(traceParentKey{}).(string)
+
+	return traceParent
+}
+
+// newMeta returns metadata of the new task.
+func newMeta(ctx context.Context, origin models.Origin) models.Meta {
+	return models.Meta{
+		FirstEnqueuedAt: time.Now().UTC(),
+		Origin:          origin,
+		TraceParent:     TraceParentFromContext(ctx),
+		Attempt:         1,
+	}
+}
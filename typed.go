@@ -0,0 +1,110 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// TypedHandler handles task with strongly typed payload.
+type TypedHandler[T any] func(ctx context.Context, payload T) error
+
+// TaskDef is a strongly typed task definition returned by Define.
+type TaskDef[T any] struct {
+	tasker Tasker
+	name   string
+}
+
+// Define declares typed task and registers its handler. Handler may be nil for services
+// which only enqueue the task. Task with payload which can't be decoded is not retried.
+func Define[T any](
+	tasker Tasker,
+	taskName string,
+	handler TypedHandler[T],
+	opts ...HandlerOption,
+) (*TaskDef[T], error) {
+	if handler != nil {
+		err := tasker.RegisterHandlerCtx(taskName, func(ctx context.Context, task TaskInfo) error {
+			payload, err := decodePayload[T](task)
+			if err != nil {
+				return Permanent(fmt.Errorf("%w: %w: task_name=%s", ErrDecodePayload, err, task.Name))
+			}
+
+			return handler(ctx, payload)
+		}, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrDefine, err)
+		}
+	}
+
+	return &TaskDef[T]{tasker: tasker, name: taskName}, nil
+}
+
+// Name returns task name.
+func (td *TaskDef[T]) Name() string {
+	return td.name
+}
+
+// Enqueue creates task with passed payload and returns its ID.
+func (td *TaskDef[T]) Enqueue(ctx context.Context, payload T, opts ...CreateOption) (string, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrEnqueue, err)
+	}
+
+	id, err := td.tasker.Create(ctx, td.name, nil, append(opts, WithPayload(raw))...)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrEnqueue, err)
+	}
+
+	return id, nil
+}
+
+// decodePayload decodes task payload. Messages created with plain Create carry only params,
+// in this case params are decoded as JSON object: first as strings, then with values
+// which look like JSON numbers or booleans taken as is.
+func decodePayload[T any](task TaskInfo) (T, error) {
+	var payload T
+
+	if len(task.Payload) > 0 {
+		if err := json.Unmarshal(task.Payload, &payload); err != nil {
+			return payload, fmt.Errorf("payload: %w", err)
+		}
+
+		return payload, nil
+	}
+
+	raw, err := json.Marshal(task.Params)
+	if err != nil {
+		return payload, fmt.Errorf("params: %w", err)
+	}
+
+	if err = json.Unmarshal(raw, &payload); err == nil {
+		return payload, nil
+	}
+
+	inferred := make(map[string]json.RawMessage, len(task.Params))
+
+	for key, value := range task.Params {
+		if json.Valid([]byte(value)) {
+			inferred[key] = json.RawMessage(value)
+			continue
+		}
+
+		quoted, _ := json.Marshal(value)
+		inferred[key] = quoted
+	}
+
+	raw, err = json.Marshal(inferred)
+	if err != nil {
+		return payload, fmt.Errorf("params: %w", err)
+	}
+
+	var typed T
+
+	if err = json.Unmarshal(raw, &typed); err != nil {
+		return payload, fmt.Errorf("params: %w", err)
+	}
+
+	return typed, nil
+}
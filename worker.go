@@ -7,7 +7,7 @@ import (
 	"context"
 	"fmt"
 	"math"
-	"strconv"
+	"math/rand/v2"
 	"time"
 
 	"gitlab.local.iti.domain/mc2/golibs/tasks/logger"
@@ -15,17 +15,34 @@ import (
 )
 
 const (
-	defaultScheduledTaskDuration = 5 * time.Second
-	minTimerDuration             = 100 * time.Millisecond
+	// leaseRenewDivider defines how many times scheduler lease is renewed during its TTL.
+	leaseRenewDivider = 3
+	minTimerDuration  = 100 * time.Millisecond
+	// maxHistory is a maximal amount of failed attempts kept in history of the task.
+	maxHistory = 10
 )
 
 func (t *Tasks) startWorkers(ctx context.Context) {
 	// Start regular task workers
 	for i := 0; i < t.opts.numWorkers; i++ {
 		t.wg.Add(1)
-		go t.taskWorker(ctx, i+1, t.taskQueue)
+		go t.taskWorker(ctx, i+1, t.pool, i)
 	}
 
+	// Start workers of handlers with own concurrency
+	t.tasksHandlersMutex.Lock()
+	t.workersStarted = true
+
+	for _, h := range t.tasksHandlers {
+		if h.pool != nil {
+			t.startHandlerWorkers(ctx, h)
+		}
+	}
+
+	t.tasksHandlersMutex.Unlock()
+
+	t.startQueueWorkers(ctx)
+
 	// Start retry worker
 	t.wgRetry.Add(1)
 	go t.retryTaskWorker(ctx)
@@ -34,63 +51,222 @@ func (t *Tasks) startWorkers(ctx context.Context) {
 	t.wgDelayed.Add(1)
 	go t.delayedTaskWorker(ctx)
 
-	// Start scheduled task worker
-	go t.scheduledTaskWorker(ctx)
+	// Start scheduled task worker, it's stopped by Stop()
+	t.wgScheduled.Add(1)
+	go t.scheduledTaskWorker(t.stopCtx)
 }
 
-func (t *Tasks) taskWorker(ctx context.Context, workerID int, taskQueue <-chan models.Task) {
+// taskWorker processes tasks of the common queue of the pool and tasks of its shard.
+func (t *Tasks) taskWorker(ctx context.Context, workerID int, pool *workerPool, shard int) {
 	defer t.wg.Done()
 
-	for {
+	taskQueue, shardQueue := pool.queue, pool.shards[shard]
+
+	for taskQueue != nil || shardQueue != nil {
 		select {
 		case <-ctx.Done():
 			t.opts.logger.Logf(logger.LogLevelInfo, "worker %d shutting down", nil, workerID)
 			return
-		case task, ok := <-taskQueue:
+		case queued, ok := <-taskQueue:
 			if !ok {
-				t.opts.logger.Logf(logger.LogLevelInfo, "worker %d: task queue closed", nil, workerID)
-				return
+				taskQueue = nil
+				continue
 			}
 
-			if err := t.processTask(ctx, task); err != nil {
-				t.opts.logger.Logf(logger.LogLevelError, "worker %d: processTask error: %s",
-					map[string]interface{}{"task_name": task.Name}, workerID, err.Error())
+			t.runTask(ctx, workerID, queued)
+		case queued, ok := <-shardQueue:
+			if !ok {
+				shardQueue = nil
+				continue
 			}
+
+			t.runKeyedTask(ctx, workerID, queued)
+		}
+	}
+
+	t.opts.logger.Logf(logger.LogLevelInfo, "worker %d: task queue closed", nil, workerID)
+}
+
+// runKeyedTask processes task with key and parked tasks of the key which are unblocked by it.
+func (t *Tasks) runKeyedTask(ctx context.Context, workerID int, queued queuedTask) {
+	queued, generation, ok := t.acquireKey(queued)
+
+	for ok {
+		t.runTask(ctx, workerID, queued)
+
+		queued, generation, ok = t.releaseKey(queued.task, generation)
+	}
+}
+
+func (t *Tasks) runTask(ctx context.Context, workerID int, queued queuedTask) {
+	task := queued.task
+
+	if err := t.processTask(ctx, task); err != nil {
+		t.opts.logger.Logf(logger.LogLevelError, "worker %d: processTask error: %s",
+			map[string]interface{}{"task_name": task.Name, "task_id": task.ID}, workerID, err.Error())
+	}
+
+	// Task is done, retried or dead-lettered, so its message isn't needed anymore.
+	if queued.msg != nil {
+		t.ack(t.opts.ctx, queued.msg)
+	}
+}
+
+// startHandlerWorkers starts workers of handler with own queue.
+func (t *Tasks) startHandlerWorkers(ctx context.Context, h *taskHandler) {
+	for i := 0; i < h.opts.concurrency; i++ {
+		t.wg.Add(1)
+		go t.taskWorker(ctx, i+1, h.pool, i)
+	}
+}
+
+// closeHandlerQueues closes dedicated queues of handlers, so their workers exit.
+func (t *Tasks) closeHandlerQueues() {
+	t.tasksHandlersMutex.Lock()
+	defer t.tasksHandlersMutex.Unlock()
+
+	t.workersStarted = false
+
+	for _, h := range t.tasksHandlers {
+		if h.pool != nil {
+			h.pool.close()
 		}
 	}
 }
 
+// queuedTasks returns amount of tasks waiting for workers in all queues and parked by blocked keys.
+func (t *Tasks) queuedTasks() int {
+	t.tasksHandlersMutex.RLock()
+	defer t.tasksHandlersMutex.RUnlock()
+
+	queued := t.pool.len()
+
+	for _, h := range t.tasksHandlers {
+		if h.pool != nil {
+			queued += h.pool.len()
+		}
+	}
+
+	for _, q := range t.queues {
+		queued += q.pool.len()
+	}
+
+	return queued + t.parkedTasks()
+}
+
 func (t *Tasks) processTask(ctx context.Context, task models.Task) error {
 	t.tasksHandlersMutex.RLock()
 	handler, ok := t.tasksHandlers[task.Name]
 	t.tasksHandlersMutex.RUnlock()
 
 	if !ok {
-		return fmt.Errorf("%w: %w: task_name=%s", errProcessTask, ErrTaskNameNotRegistered, task.Name)
+		return fmt.Errorf("%w: %w: task_name=%s, task_id=%s",
+			errProcessTask, ErrTaskNameNotRegistered, task.Name, task.ID)
+	}
+
+	if t.isCancelled(task.ID) || t.isCancelledInStore(ctx, task.ID) {
+		t.opts.logger.Logf(logger.LogLevelInfo, "skipping cancelled task: %s",
+			map[string]interface{}{"task_name": task.Name, "task_id": task.ID}, task.Name)
+
+		return nil
 	}
 
-	isScheduled := task.Params["scheduled"] == "true"
-	isDelayed := task.Params["delayed"] == "true"
+	var generation uint64
+
+	if handler.breaker != nil {
+		var (
+			allowed bool
+			wait    time.Duration
+		)
+
+		allowed, wait, generation = handler.breaker.Allow()
+		if !allowed {
+			return t.deferTask(ctx, task, wait)
+		}
+	}
+
+	isScheduled := task.Meta.Origin == models.OriginScheduled
 
 	t.opts.logger.Logf(logger.LogLevelInfo, "processing task: %s",
 		map[string]interface{}{
 			"task_name": task.Name,
-			"scheduled": isScheduled,
-			"delayed":   isDelayed,
+			"task_id":   task.ID,
+			"origin":    task.Meta.Origin,
+			"attempt":   task.Meta.Attempt,
 		}, task.Name)
 
-	if err := handler(task.Params); err != nil {
+	t.updateStatus(ctx, task, func(status *models.TaskStatus) {
+		status.State = models.TaskStateRunning
+		status.Attempts = task.Meta.Attempt
+		status.StartedAt = time.Now().UTC()
+		status.NextRunAt = time.Time{}
+	})
+
+	startedAt := time.Now().UTC()
+
+	execCtx, cancel := context.WithTimeout(t.stopCtx, handler.timeout)
+	defer cancel()
+
+	if task.Meta.TraceParent != "" {
+		execCtx = ContextWithTraceParent(execCtx, task.Meta.TraceParent)
+	}
+
+	info := TaskInfo{
+		FireTime: task.FireTime,
+		Params:   task.Params,
+		ID:       task.ID,
+		Name:     task.Name,
+		Payload:  task.Payload,
+		meta:     task.Meta,
+	}
+
+	err := handler.handler(execCtx, info)
+
+	if handler.breaker != nil {
+		// Permanent errors are caused by the task itself, not by failing dependency.
+		handler.breaker.Done(generation, err == nil || IsPermanent(err))
+	}
+
+	if err != nil {
+		task.History = appendAttempt(task.History, models.Attempt{
+			StartedAt:  startedAt,
+			FinishedAt: time.Now().UTC(),
+			Error:      err.Error(),
+		})
+
 		// Don't retry scheduled tasks, they will run again on schedule
 		if !isScheduled {
-			t.addToRetryQueue(task)
+			t.addToRetryQueue(ctx, task, err, handler)
+		} else {
+			t.updateStatus(ctx, task, func(status *models.TaskStatus) {
+				status.State = models.TaskStateDead
+				status.LastError = err.Error()
+				status.FinishedAt = time.Now().UTC()
+			})
 		}
 
 		return fmt.Errorf("%w: %w", errProcessTask, err)
 	}
 
+	t.updateStatus(ctx, task, func(status *models.TaskStatus) {
+		status.State = models.TaskStateSucceeded
+		status.FinishedAt = time.Now().UTC()
+	})
+
 	return nil
 }
 
+// appendAttempt adds failed attempt to the history. History is sent with every retry, so only the first
+// attempt and the last maxHistory-1 ones are kept.
+func appendAttempt(history []models.Attempt, attempt models.Attempt) []models.Attempt {
+	history = append(history, attempt)
+	if len(history) <= maxHistory {
+		return history
+	}
+
+	return append(history[:1], history[len(history)-maxHistory+1:]...)
+}
+
 // retryTaskWorker processes tasks that need to be retried with exponential backoff.
 // It uses a priority queue (heap) to efficiently manage tasks by their retry time.
 func (t *Tasks) retryTaskWorker(ctx context.Context) {
@@ -133,17 +309,22 @@ func (t *Tasks) retryTaskWorker(ctx context.Context) {
 				// Pop and process the task
 				task := heap.Pop(retryQueue).(*RetryTask)
 
-				t.opts.logger.Logf(logger.LogLevelInfo, "retrying task: %s (attempt %s)",
+				if t.isCancelled(task.Task.ID) {
+					continue
+				}
+
+				t.opts.logger.Logf(logger.LogLevelInfo, "retrying task: %s (attempt %d)",
 					map[string]interface{}{
 						"task_name": task.Task.Name,
-						"attempts":  task.Task.Params["attempts"],
+						"task_id":   task.Task.ID,
+						"attempt":   task.Task.Meta.Attempt,
 					},
-					task.Task.Name, task.Task.Params["attempts"])
+					task.Task.Name, task.Task.Meta.Attempt)
 
-				err := t.Create(ctx, task.Task.Name, task.Task.Params)
+				err := t.publish(ctx, task.Task)
 				if err != nil {
 					t.opts.logger.Logf(logger.LogLevelError, "retry task create error: %s",
-						map[string]interface{}{"task_name": task.Task.Name}, err.Error())
+						map[string]interface{}{"task_name": task.Task.Name, "task_id": task.Task.ID}, err.Error())
 				}
 			}
 
@@ -152,17 +333,29 @@ func (t *Tasks) retryTaskWorker(ctx context.Context) {
 				t.opts.logger.Log(logger.LogLevelDebug, "retry queue is empty", nil)
 			}
 
+		case id := <-t.cancelRetry:
+			if retryQueue.Remove(id) {
+				t.opts.logger.Logf(logger.LogLevelInfo, "cancelled task removed from retry queue: %s",
+					map[string]interface{}{"task_id": id}, id)
+			}
+
 		case task, ok := <-t.retryQueue:
 			if !ok {
 				// Channel closed, process remaining tasks and exit
 				t.opts.logger.Logf(logger.LogLevelInfo, "retry queue channel closed, processing remaining %d tasks",
 					nil, retryQueue.Len())
 
+				// StartTime is kept, so consumer of the task postpones it till backoff is over.
 				for retryQueue.Len() > 0 {
 					retryTask := heap.Pop(retryQueue).(*RetryTask)
-					err := t.Create(ctx, retryTask.Task.Name, retryTask.Task.Params)
+					if t.isCancelled(retryTask.Task.ID) {
+						continue
+					}
+
+					err := t.publish(ctx, retryTask.Task)
 					if err != nil {
-						t.opts.logger.Logf(logger.LogLevelError, "final retry task create error: %s", nil, err.Error())
+						t.opts.logger.Logf(logger.LogLevelError, "final retry task create error: %s",
+							map[string]interface{}{"task_name": retryTask.Task.Name, "task_id": retryTask.Task.ID}, err.Error())
 					}
 				}
 				return
@@ -179,6 +372,7 @@ func (t *Tasks) retryTaskWorker(ctx context.Context) {
 			t.opts.logger.Logf(logger.LogLevelDebug, "added task to retry queue: %s at %s",
 				map[string]interface{}{
 					"task_name": task.Name,
+					"task_id":   task.ID,
 					"retry_at":  task.StartTime.Format(time.RFC3339),
 				},
 				task.Name, task.StartTime.Format(time.RFC3339))
@@ -239,20 +433,22 @@ func (t *Tasks) delayedTaskWorker(ctx context.Context) {
 
 				task := heap.Pop(delayedQueue).(*RetryTask)
 
+				if t.isCancelled(task.Task.ID) {
+					continue
+				}
+
 				t.opts.logger.Logf(logger.LogLevelInfo, "executing delayed task: %s",
 					map[string]interface{}{
 						"task_name":     task.Task.Name,
+						"task_id":       task.Task.ID,
 						"scheduled_for": task.StartTime.Format(time.RFC3339),
 					},
 					task.Task.Name)
 
-				// Remove delayed flag before processing
-				delete(task.Task.Params, "delayed")
-
-				err := t.Create(ctx, task.Task.Name, task.Task.Params)
+				err := t.publish(ctx, task.Task)
 				if err != nil {
 					t.opts.logger.Logf(logger.LogLevelError, "delayed task create error: %s",
-						map[string]interface{}{"task_name": task.Task.Name}, err.Error())
+						map[string]interface{}{"task_name": task.Task.Name, "task_id": task.Task.ID}, err.Error())
 				}
 			}
 
@@ -260,17 +456,29 @@ func (t *Tasks) delayedTaskWorker(ctx context.Context) {
 				t.opts.logger.Log(logger.LogLevelDebug, "delayed queue is empty", nil)
 			}
 
+		case id := <-t.cancelDelayed:
+			if delayedQueue.Remove(id) {
+				t.opts.logger.Logf(logger.LogLevelInfo, "cancelled task removed from delayed queue: %s",
+					map[string]interface{}{"task_id": id}, id)
+			}
+
 		case task, ok := <-t.delayedQueue:
 			if !ok {
 				t.opts.logger.Logf(logger.LogLevelInfo, "delayed queue channel closed, processing remaining %d tasks",
 					nil, delayedQueue.Len())
 
+				// StartTime is kept, so consumer of the task postpones it till due.
 				for delayedQueue.Len() > 0 {
 					delayedTask := heap.Pop(delayedQueue).(*RetryTask)
-					delete(delayedTask.Task.Params, "delayed")
-					err := t.Create(ctx, delayedTask.Task.Name, delayedTask.Task.Params)
+					if t.isCancelled(delayedTask.Task.ID) {
+						continue
+					}
+
+					err := t.publish(ctx, delayedTask.Task)
 					if err != nil {
-						t.opts.logger.Logf(logger.LogLevelError, "final delayed task create error: %s", nil, err.Error())
+						t.opts.logger.Logf(logger.LogLevelError, "final delayed task create error: %s",
+							map[string]interface{}{"task_name": delayedTask.Task.Name, "task_id": delayedTask.Task.ID},
+							err.Error())
 					}
 				}
 				return
@@ -286,6 +494,7 @@ func (t *Tasks) delayedTaskWorker(ctx context.Context) {
 			t.opts.logger.Logf(logger.LogLevelDebug, "added task to delayed queue: %s at %s",
 				map[string]interface{}{
 					"task_name":  task.Name,
+					"task_id":    task.ID,
 					"execute_at": task.StartTime.Format(time.RFC3339),
 				},
 				task.Name, task.StartTime.Format(time.RFC3339))
@@ -307,93 +516,334 @@ func (t *Tasks) delayedTaskWorker(ctx context.Context) {
 }
 
 func (t *Tasks) scheduledTaskWorker(ctx context.Context) {
-	ticker := time.NewTicker(defaultScheduledTaskDuration)
-	defer ticker.Stop()
+	defer t.wgScheduled.Done()
+
+	scheduleQueue := &RetryQueue{}
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	defer timer.Stop()
+
+	// Lease is renewed in background, so idle instance keeps it and fire time is not delayed by Acquire.
+	var renew <-chan time.Time
+
+	if t.opts.leaseBackend != nil {
+		ticker := time.NewTicker(t.opts.leaseTTL / leaseRenewDivider)
+		defer ticker.Stop()
+
+		renew = ticker.C
+	}
+
+	leader := t.acquireSchedulerLease(ctx)
+	t.loadScheduleQueue(scheduleQueue, leader)
 
 	t.opts.logger.Log(logger.LogLevelInfo, "scheduled task worker started", nil)
 
 	for {
+		if scheduleQueue.Len() > 0 {
+			timer.Reset(time.Until((*scheduleQueue)[0].StartTime))
+		}
+
 		select {
 		case <-ctx.Done():
 			t.opts.logger.Log(logger.LogLevelInfo, "scheduled task worker shutting down", nil)
 			return
-		case <-ticker.C:
-			t.processScheduledTasks(ctx)
+
+		case <-t.scheduleChanged:
+			t.loadScheduleQueue(scheduleQueue, leader)
+
+		case <-renew:
+			wasLeader := leader
+
+			leader = t.acquireSchedulerLease(ctx)
+			if leader != wasLeader {
+				t.loadScheduleQueue(scheduleQueue, leader)
+			}
+
+		case <-timer.C:
+			t.processScheduledTasks(ctx, scheduleQueue, leader)
+		}
+
+		// Timer is reset on every iteration, drain it if it has fired meanwhile.
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+}
+
+// notifyScheduleChanged wakes scheduled task worker up to reload schedules.
+func (t *Tasks) notifyScheduleChanged() {
+	select {
+	case t.scheduleChanged <- struct{}{}:
+	default:
+	}
+}
+
+// loadScheduleQueue rebuilds heap of active schedules ordered by next occurrence.
+func (t *Tasks) loadScheduleQueue(scheduleQueue *RetryQueue, leader bool) {
+	t.scheduledTaskMutex.RLock()
+	defer t.scheduledTaskMutex.RUnlock()
+
+	*scheduleQueue = (*scheduleQueue)[:0]
+
+	for _, entry := range t.scheduledTasks {
+		if entry.paused || entry.task.TimeOfNextExec.IsZero() {
+			continue
 		}
+
+		*scheduleQueue = append(*scheduleQueue, &RetryTask{
+			StartTime: t.scheduleDueTime(entry.task.TimeOfNextExec, leader),
+			Task:      entry.task,
+		})
+	}
+
+	heap.Init(scheduleQueue)
+}
+
+// scheduleDueTime returns time when occurrence is handled by the scheduled task worker. Follower
+// handles it one lease TTL later: leader could die without creating the occurrence, so it's kept and
+// created by the follower if it becomes leader meanwhile.
+func (t *Tasks) scheduleDueTime(next time.Time, leader bool) time.Time {
+	if leader {
+		return next
+	}
+
+	return next.Add(t.opts.leaseTTL)
+}
+
+// processScheduledTasks creates tasks for due schedules and pushes them back with next occurrence.
+func (t *Tasks) processScheduledTasks(ctx context.Context, scheduleQueue *RetryQueue, leader bool) {
+	now := time.Now().UTC()
+
+	// Occurrences are published without the lock, so slow broker doesn't block schedule management.
+	for _, o := range t.dueOccurrences(scheduleQueue, leader, now) {
+		t.createOccurrence(ctx, o.task, o.fireTime, now)
 	}
 }
 
-func (t *Tasks) processScheduledTasks(ctx context.Context) {
+// dueOccurrence is an occurrence of the schedule which is due to be created.
+type dueOccurrence struct {
+	fireTime time.Time
+	task     models.Task
+}
+
+// dueOccurrences advances schedules which are due and returns their occurrences to be created.
+func (t *Tasks) dueOccurrences(scheduleQueue *RetryQueue, leader bool, now time.Time) []dueOccurrence {
 	t.scheduledTaskMutex.Lock()
 	defer t.scheduledTaskMutex.Unlock()
 
-	now := time.Now().UTC()
+	var occurrences []dueOccurrence
+
+	for scheduleQueue.Len() > 0 && !(*scheduleQueue)[0].StartTime.After(now) {
+		item := heap.Pop(scheduleQueue).(*RetryTask)
+
+		// Schedule could be changed after the queue was loaded, reload request is already pending then.
+		entry, ok := t.scheduledTasks[item.Task.Name]
+		if !ok || entry.paused || entry.task.ID != item.Task.ID ||
+			!entry.task.TimeOfNextExec.Equal(item.Task.TimeOfNextExec) {
+			continue
+		}
+
+		var (
+			fireTimes []time.Time
+			missed    int
+		)
+
+		// Every instance tracks schedules, but only lease owner creates tasks. Follower drops only
+		// occurrences which are older than lease TTL, so new leader catches up the rest.
+		if leader {
+			fireTimes, missed = entry.advance(now)
+		} else {
+			entry.task.TimeOfNextExec = nextAfter(entry.schedule, entry.task.TimeOfNextExec,
+				now.Add(-t.opts.leaseTTL))
+		}
 
-	for name, task := range t.scheduledTasks {
-		if task.TimeOfNextExec.Before(now) || task.TimeOfNextExec.Equal(now) {
-			t.opts.logger.Logf(logger.LogLevelDebug, "executing scheduled task: %s",
+		if missed > 0 {
+			t.opts.logger.Logf(logger.LogLevelInfo, "scheduled task misfired: %s (missed: %d, policy: %s)",
 				map[string]interface{}{
-					"task_name": task.Name,
-					"next_exec": task.TimeOfNextExec.Format(time.RFC3339),
+					"task_name":   entry.task.Name,
+					"schedule_id": entry.task.ID,
+					"missed":      missed,
+					"policy":      entry.opts.misfirePolicy,
 				},
-				task.Name)
+				entry.task.Name, missed, entry.opts.misfirePolicy)
+		}
 
-			task.TimeOfNextExec = task.TimeOfNextExec.Add(task.Period)
-			t.scheduledTasks[name] = task
+		if entry.task.TimeOfNextExec.IsZero() {
+			t.opts.logger.Logf(logger.LogLevelInfo, "scheduled task has no more occurrences: %s",
+				map[string]interface{}{"task_name": entry.task.Name, "schedule_id": entry.task.ID}, entry.task.Name)
 
-			err := t.Create(ctx, task.Name, task.Params)
-			if err != nil {
-				t.opts.logger.Logf(logger.LogLevelError, "scheduled task create error: %s",
-					map[string]interface{}{"task_name": task.Name}, err.Error())
-			}
+			delete(t.scheduledTasks, entry.task.Name)
+		} else {
+			heap.Push(scheduleQueue, &RetryTask{
+				StartTime: t.scheduleDueTime(entry.task.TimeOfNextExec, leader),
+				Task:      entry.task,
+			})
 		}
+
+		for _, fireTime := range fireTimes {
+			occurrences = append(occurrences, dueOccurrence{fireTime: fireTime, task: entry.task})
+		}
+	}
+
+	return occurrences
+}
+
+// createOccurrence creates task for single occurrence of the schedule.
+func (t *Tasks) createOccurrence(ctx context.Context, task models.Task, fireTime, now time.Time) {
+	// Every occurrence is a separate task with own ID, schedule is identified by task.ID.
+	occurrence := models.Task{
+		Meta:      newMeta(ctx, models.OriginScheduled),
+		ID:        newTaskID(),
+		Name:      task.Name,
+		Params:    task.Params,
+		StartTime: now,
+		FireTime:  fireTime,
+		Version:   models.TaskVersion,
+	}
+
+	t.opts.logger.Logf(logger.LogLevelDebug, "executing scheduled task: %s",
+		map[string]interface{}{
+			"task_name":   task.Name,
+			"task_id":     occurrence.ID,
+			"schedule_id": task.ID,
+			"fire_time":   fireTime.Format(time.RFC3339),
+			"next_exec":   task.TimeOfNextExec.Format(time.RFC3339),
+		},
+		task.Name)
+
+	t.updateStatus(ctx, occurrence, func(status *models.TaskStatus) {
+		status.State = models.TaskStatePending
+	})
+
+	err := t.publish(ctx, occurrence)
+	if err != nil {
+		t.opts.logger.Logf(logger.LogLevelError, "scheduled task create error: %s",
+			map[string]interface{}{"task_name": task.Name, "task_id": occurrence.ID, "schedule_id": task.ID},
+			err.Error())
 	}
 }
 
-func (t *Tasks) addToRetryQueue(task models.Task) {
-	attemptsStr := task.Params["attempts"]
-	attempts, _ := strconv.Atoi(attemptsStr)
-	maxAttempts := t.opts.retryPolicy.MaximumAttempts
+func (t *Tasks) addToRetryQueue(ctx context.Context, task models.Task, taskErr error, h *taskHandler) {
+	if t.isCancelled(task.ID) {
+		return
+	}
+
+	if !h.opts.shouldRetry(taskErr) {
+		t.opts.logger.Logf(logger.LogLevelInfo, "task failed with non-retryable error: %s",
+			map[string]interface{}{"task_name": task.Name, "task_id": task.ID}, task.Name)
+
+		t.deadLetter(ctx, task, taskErr, models.DeadLetterPermanent)
+
+		return
+	}
+
+	// Amount of retries which were already made.
+	attempts := task.Meta.Attempt - 1
+	maxAttempts := h.retryPolicy.MaximumAttempts
 
 	if maxAttempts > 0 && attempts >= maxAttempts {
 		t.opts.logger.Logf(logger.LogLevelInfo, "max retry attempts exceeded for task: %s (attempts: %d)",
 			map[string]interface{}{
 				"task_name":    task.Name,
+				"task_id":      task.ID,
 				"attempts":     attempts,
 				"max_attempts": maxAttempts,
 			},
 			task.Name, attempts)
+
+		t.deadLetter(ctx, task, taskErr, models.DeadLetterMaxAttempts)
+
 		return
 	}
 
 	attempts++
-	backoff := t.calculateBackoff(attempts)
+	backoff := calculateBackoff(h.retryPolicy, attempts, task.RetryDelay, taskErr)
 
-	task.Params["attempts"] = strconv.Itoa(attempts)
+	// Handler knows better when to retry, e.g. from Retry-After of external API.
+	if delay, ok := retryDelay(taskErr); ok {
+		backoff = delay
+	}
+
+	task.Meta.Attempt = attempts + 1
+	task.Meta.Origin = models.OriginRetry
+	task.Meta.LastError = taskErr.Error()
+	task.RetryDelay = backoff
 	task.StartTime = time.Now().UTC().Add(backoff)
 
 	t.opts.logger.Logf(logger.LogLevelInfo, "scheduling retry for task: %s (attempt %d, delay: %s)",
 		map[string]interface{}{
 			"task_name": task.Name,
+			"task_id":   task.ID,
 			"attempts":  attempts,
 			"backoff":   backoff.String(),
 		},
 		task.Name, attempts, backoff.String())
 
+	if t.retries != nil {
+		err := t.publishDelayed(ctx, t.retries, task)
+		if err != nil {
+			t.opts.logger.Logf(logger.LogLevelError, "publish retry error: %s",
+				map[string]interface{}{"task_name": task.Name, "task_id": task.ID}, err.Error())
+
+			t.deadLetter(ctx, task, taskErr, models.DeadLetterRetryFailed)
+
+			return
+		}
+
+		t.markRetrying(ctx, task, taskErr)
+		t.blockKey(task)
+
+		return
+	}
+
 	select {
 	case t.retryQueue <- task:
 		// Successfully added to retry queue
+		t.markRetrying(ctx, task, taskErr)
+		t.blockKey(task)
 	default:
 		t.opts.logger.Logf(logger.LogLevelError, "retry queue is full, dropping task: %s",
-			map[string]interface{}{"task_name": task.Name}, task.Name)
+			map[string]interface{}{"task_name": task.Name, "task_id": task.ID}, task.Name)
+
+		t.deadLetter(ctx, task, taskErr, models.DeadLetterRetryFailed)
 	}
 }
 
-// calculateBackoff computes the retry delay for exponential backoff.
-func (t *Tasks) calculateBackoff(attempts int) time.Duration {
-	retryPolicy := t.opts.retryPolicy
+// calculateBackoff computes the retry delay according to strategy and jitter of the policy.
+// previous is a delay before the previous attempt, it's zero for the first retry.
+func calculateBackoff(retryPolicy models.RetryPolicy, attempts int, previous time.Duration, err error) time.Duration {
+	if retryPolicy.BackoffFunc != nil {
+		// Custom schedule is limited by the policy as well, negative delay means retry at once.
+		return min(max(retryPolicy.BackoffFunc(attempts, err), 0), retryPolicy.MaximumInterval)
+	}
+
+	initial := float64(retryPolicy.InitialInterval)
 
-	backoff := float64(retryPolicy.InitialInterval) * math.Pow(retryPolicy.BackoffCoefficient, float64(attempts-1))
+	var backoff float64
+
+	switch retryPolicy.Strategy {
+	case models.BackoffFixed:
+		backoff = initial
+	case models.BackoffLinear:
+		backoff = initial * float64(attempts)
+	default:
+		backoff = initial * math.Pow(retryPolicy.BackoffCoefficient, float64(attempts-1))
+	}
+
+	switch retryPolicy.Jitter {
+	case models.JitterFull:
+		backoff = rand.Float64() * backoff //nolint:gosec
+	case models.JitterEqual:
+		backoff = backoff/2 + rand.Float64()*backoff/2 //nolint:gosec
+	case models.JitterDecorrelated:
+		upper := math.Max(float64(previous)*3, initial)
+		backoff = initial + rand.Float64()*(upper-initial) //nolint:gosec
+	}
 
 	if backoff > float64(retryPolicy.MaximumInterval) {
 		backoff = float64(retryPolicy.MaximumInterval)